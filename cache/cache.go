@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+
+	"github.com/buildpack/lifecycle/img"
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/droplet"
+)
+
+// Pull extracts every layer of the cache image in store into dir. Layers
+// are extracted like droplets, so that they cannot write outside of dir.
+func Pull(store img.Store, dir string) error {
+	image, err := store.Image()
+	if err != nil {
+		return packs.FailErr(err, "get cache image")
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return packs.FailErr(err, "get cache layers")
+	}
+	for _, layer := range layers {
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return packs.FailErr(err, "read cache layer")
+		}
+		err = droplet.ExtractTar(rc, dir)
+		rc.Close()
+		if err != nil {
+			return packs.FailErr(err, "extract cache layer to", dir)
+		}
+	}
+	return nil
+}
+
+// Push writes each top-level directory in dir (one per buildpack) to its
+// own layer of the cache image in store. Layers are mountable from the
+// cache repository, so unchanged layers are not uploaded again.
+func Push(store img.Store, dir string) error {
+	tmpDir, err := ioutil.TempDir("", "pack.cache")
+	if err != nil {
+		return packs.FailErr(err, "create temp directory")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return packs.FailErr(err, "read", dir)
+	}
+	var layers []v1.Layer
+	for i, f := range files {
+		tgz := filepath.Join(tmpDir, fmt.Sprintf("%d.tgz", i))
+		if err := compress(dir, f.Name(), tgz); err != nil {
+			return packs.FailErr(err, "compress", f.Name())
+		}
+		layer, err := tarball.LayerFromFile(tgz)
+		if err != nil {
+			return packs.FailErr(err, "create layer from", f.Name())
+		}
+		layers = append(layers, &remote.MountableLayer{Layer: layer, Reference: store.Ref()})
+	}
+	image, err := mutate.AppendLayers(empty.Image, layers...)
+	if err != nil {
+		return packs.FailErr(err, "create cache image")
+	}
+	if err := store.Write(image); err != nil {
+		return packs.FailErr(err, "write cache image")
+	}
+	return nil
+}
+
+func compress(dir, name, tgz string) error {
+	f, err := os.Create(tgz)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	if err := filepath.Walk(filepath.Join(dir, name), func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		if hdr.Name, err = filepath.Rel(dir, path); err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(hdr.Name)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tw, file)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gzw.Close()
+}
@@ -0,0 +1,262 @@
+package cache_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/cache"
+)
+
+func TestCache(t *testing.T) {
+	spec.Run(t, "#Push", testPush)
+	spec.Run(t, "#Pull", testPull)
+}
+
+func testPush(t *testing.T, when spec.G, it spec.S) {
+	var (
+		store  *fakeStore
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.cache.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		store = newFakeStore(t)
+		writeFile(t, filepath.Join(tmpDir, "src", "bp1", "some-file"), "some-contents")
+		writeFile(t, filepath.Join(tmpDir, "src", "bp2", "sub", "other-file"), "other-contents")
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should write one layer per buildpack cache directory", func() {
+		if err := cache.Push(store, filepath.Join(tmpDir, "src")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		layers, err := store.image.Layers()
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if n := len(layers); n != 2 {
+			t.Fatalf("Expected 2 layers, got %d\n", n)
+		}
+	})
+
+	it("should produce identical layers for a restored cache", func() {
+		if err := cache.Push(store, filepath.Join(tmpDir, "src")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		first := digests(t, store.image)
+		if err := cache.Pull(store, filepath.Join(tmpDir, "dst")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := cache.Push(store, filepath.Join(tmpDir, "dst")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if second := digests(t, store.image); !reflect.DeepEqual(first, second) {
+			t.Fatalf("Mismatched layers:\n%v\n!=\n%v\n", first, second)
+		}
+	})
+}
+
+func testPull(t *testing.T, when spec.G, it spec.S) {
+	var (
+		store  *fakeStore
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.cache.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		store = newFakeStore(t)
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should restore every buildpack cache directory", func() {
+		writeFile(t, filepath.Join(tmpDir, "src", "bp1", "some-file"), "some-contents")
+		writeFile(t, filepath.Join(tmpDir, "src", "bp2", "sub", "other-file"), "other-contents")
+		if err := cache.Push(store, filepath.Join(tmpDir, "src")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+
+		if err := cache.Pull(store, filepath.Join(tmpDir, "dst")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+
+		for file, expected := range map[string]string{
+			filepath.Join("bp1", "some-file"):         "some-contents",
+			filepath.Join("bp2", "sub", "other-file"): "other-contents",
+		} {
+			contents, err := ioutil.ReadFile(filepath.Join(tmpDir, "dst", file))
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if string(contents) != expected {
+				t.Fatalf("%s: %s != %s\n", file, contents, expected)
+			}
+		}
+	})
+
+	when("a cache layer writes through a symlink outside of the directory", func() {
+		it("should return an error without writing outside of the directory", func() {
+			layer := layerFromTar(t,
+				&tar.Header{Name: "bp1/", Typeflag: tar.TypeDir, Mode: 0755},
+				&tar.Header{Name: "bp1/some-link", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+				&tar.Header{Name: "bp1/some-link/some-file", Typeflag: tar.TypeReg, Mode: 0644},
+			)
+			image, err := mutate.AppendLayers(empty.Image, layer)
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			store.image = image
+
+			if err := cache.Pull(store, filepath.Join(tmpDir, "dst")); err == nil {
+				t.Fatal("Expected error")
+			}
+			if _, err := os.Lstat(filepath.Join(tmpDir, "some-file")); !os.IsNotExist(err) {
+				t.Fatal("Expected no file outside of the directory")
+			}
+		})
+	})
+
+	when("there is no cache image", func() {
+		it("should return an error", func() {
+			if err := cache.Pull(store, filepath.Join(tmpDir, "dst")); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+}
+
+type fakeStore struct {
+	ref   name.Reference
+	image v1.Image
+}
+
+func newFakeStore(t *testing.T) *fakeStore {
+	t.Helper()
+	ref, err := name.ParseReference("some-registry.com/some-app-cache", name.WeakValidation)
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	return &fakeStore{ref: ref}
+}
+
+func (f *fakeStore) Ref() name.Reference {
+	return f.ref
+}
+
+func (f *fakeStore) Image() (v1.Image, error) {
+	if f.image == nil {
+		return nil, errors.New("image not found")
+	}
+	return f.image, nil
+}
+
+// Write copies the layers into memory, since they are backed by temp files.
+func (f *fakeStore) Write(image v1.Image) error {
+	layers, err := image.Layers()
+	if err != nil {
+		return err
+	}
+	var copies []v1.Layer
+	for _, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			return err
+		}
+		contents, err := ioutil.ReadAll(rc)
+		if rc.Close(); err != nil {
+			return err
+		}
+		layerCopy, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(contents)), nil
+		})
+		if err != nil {
+			return err
+		}
+		copies = append(copies, layerCopy)
+	}
+	f.image, err = mutate.AppendLayers(empty.Image, copies...)
+	return err
+}
+
+func digests(t *testing.T, image v1.Image) []string {
+	t.Helper()
+	layers, err := image.Layers()
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	var out []string
+	for _, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		out = append(out, digest.String())
+	}
+	return out
+}
+
+// layerFromTar returns a layer with the given entries, where every regular
+// file contains "some-contents".
+func layerFromTar(t *testing.T, headers ...*tar.Header) v1.Layer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len("some-contents"))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte("some-contents")); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	return layer
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+}
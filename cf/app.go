@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"strconv"
@@ -12,12 +13,18 @@ import (
 	"syscall"
 
 	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/resources"
 )
 
+const DefaultPort = 8080
+
 const (
-	kernelUUIDPath     = "/proc/sys/kernel/random/uuid"
-	cgroupMemLimitPath = "/sys/fs/cgroup/memory/memory.limit_in_bytes"
-	cgroupMemUnlimited = 9223372036854771712
+	defaultAppName = "app"
+	kernelUUIDPath = "/proc/sys/kernel/random/uuid"
+	defaultMemory  = 1024
+	defaultSysRoot = "/"
+	appFSPath      = "/home/vcap"
+	loopbackIP     = "127.0.0.1"
 )
 
 type VCAPApplication struct {
@@ -30,6 +37,8 @@ type VCAPApplication struct {
 	InstanceIndex      *uint             `json:"instance_index,omitempty"`
 	Limits             map[string]uint64 `json:"limits"`
 	Name               string            `json:"name"`
+	OrganizationID     string            `json:"organization_id"`
+	OrganizationName   string            `json:"organization_name"`
 	Port               *uint             `json:"port,omitempty"`
 	SpaceID            string            `json:"space_id"`
 	SpaceName          string            `json:"space_name"`
@@ -39,57 +48,83 @@ type VCAPApplication struct {
 
 type App struct {
 	Env func(string) (string, bool)
+	Dir string
 
 	name       string
 	mem        uint64
+	cpu        uint64
 	disk       uint64
 	fds        uint64
-	id         string
 	instanceID string
-	spaceID    string
-	version    string
 	ip         string
+	stack      string
 }
 
 func New() (*App, error) {
 	var err error
-	app := &App{Env: os.LookupEnv}
-	app.name = "app"
-	if app.mem, err = totalMem(); err != nil {
+	app := &App{Env: os.LookupEnv, Dir: "."}
+	app.name = defaultAppName
+	host := resources.Host{Root: defaultSysRoot}
+	if app.mem, err = totalMem(host); err != nil {
+		return nil, err
+	}
+	if app.cpu, err = cpuQuota(host); err != nil {
+		return nil, err
+	}
+	if app.disk, err = totalDisk(host); err != nil {
 		return nil, err
 	}
-	app.disk = 1024
 	var fds syscall.Rlimit
 	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &fds); err != nil {
 		return nil, err
 	}
 	app.fds = fds.Cur
 	if app.ip, err = containerIP(); err != nil {
+		log.Printf("Warning: using %s as container IP: %s\n", loopbackIP, err)
+		app.ip = loopbackIP
+	}
+	if app.stack, err = DetectStack(defaultSysRoot); err != nil {
 		return nil, err
 	}
-	for _, id := range []*string{&app.id, &app.instanceID, &app.spaceID, &app.version} {
-		if *id, err = uuid(); err != nil {
-			return nil, err
-		}
+	if app.instanceID, err = uuid(); err != nil {
+		return nil, err
 	}
 	return app, nil
 }
 
+// containerIP returns the first routable address assigned to an interface
+// that is up, preferring IPv4 over IPv6.
 func containerIP() (string, error) {
-	host, err := os.Hostname()
+	ifaces, err := net.Interfaces()
 	if err != nil {
 		return "", err
 	}
-	addrs, err := net.LookupIP(host)
-	if err != nil {
-		return "", err
-	}
-	for _, addr := range addrs {
-		if ip := addr.To4(); ip != nil {
-			return ip.String(), nil
+	var ipv6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return "", err
 		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || !ipNet.IP.IsGlobalUnicast() {
+				continue
+			}
+			if ip := ipNet.IP.To4(); ip != nil {
+				return ip.String(), nil
+			}
+			if ipv6 == nil {
+				ipv6 = ipNet.IP
+			}
+		}
+	}
+	if ipv6 != nil {
+		return ipv6.String(), nil
 	}
-	return "", errors.New("no valid ipv4 address found")
+	return "", errors.New("no routable address found")
 }
 
 func uuid() (string, error) {
@@ -97,47 +132,103 @@ func uuid() (string, error) {
 	return strings.TrimSpace(string(id)), err
 }
 
-func totalMem() (uint64, error) {
-	contents, err := ioutil.ReadFile(cgroupMemLimitPath)
+func totalMem(host resources.Host) (uint64, error) {
+	memBytes, limited, err := host.Memory()
 	if err != nil {
 		return 0, err
 	}
-	memBytes, err := strconv.ParseUint(strings.TrimSpace(string(contents)), 10, 64)
+	if !limited {
+		return defaultMemory, nil
+	}
+	return memBytes / 1024 / 1024, nil
+}
+
+// cpuQuota returns the CPU quota as a percentage of one CPU, or zero if
+// there is no quota.
+func cpuQuota(host resources.Host) (uint64, error) {
+	millis, _, err := host.CPU()
+	return millis / 10, err
+}
+
+func totalDisk(host resources.Host) (uint64, error) {
+	diskBytes, err := host.Disk(appFSPath)
+	if os.IsNotExist(err) {
+		diskBytes, err = host.Disk("/")
+	}
 	if err != nil {
 		return 0, err
 	}
-	if memBytes == cgroupMemUnlimited {
-		return 1024, nil
-	}
-	return memBytes / 1024 / 1024, nil
+	return diskBytes / 1024 / 1024, nil
 }
 
-func (a *App) config() (name, uri string, limits map[string]uint64) {
-	name = a.envStr(packs.EnvAppName, a.name)
-	uri = a.envStr(packs.EnvAppURI, name+".local")
+func (a *App) config() (id Identity, uri string, limits map[string]uint64, err error) {
+	if id, err = a.Identity(); err != nil {
+		return Identity{}, "", nil, err
+	}
+	uri = a.envStr(packs.EnvAppURI, id.AppName+".local")
 
 	disk := a.envInt(packs.EnvAppDisk, a.disk)
 	fds := a.envInt(packs.EnvAppFds, a.fds)
 	mem := a.envInt(packs.EnvAppMemory, a.mem)
 	limits = map[string]uint64{"disk": disk, "fds": fds, "mem": mem}
+	if cpu := a.envInt(packs.EnvAppCPU, a.cpu); cpu > 0 {
+		limits["cpu"] = cpu
+	}
+
+	return id, uri, limits, nil
+}
 
-	return name, uri, limits
+// ports returns the primary port followed by any additional ports. Like
+// the exporter, it rejects ports outside of 1-65535.
+func (a *App) ports() (packs.Ports, error) {
+	port := uint(DefaultPort)
+	if v := a.envStr(packs.EnvAppPort, ""); v != "" {
+		var err error
+		if port, err = packs.ParsePort(strings.TrimSpace(v)); err != nil {
+			return nil, packs.FailErr(err, "parse", packs.EnvAppPort)
+		}
+	}
+	ports, err := packs.ParsePorts(a.envStr(packs.EnvAppPorts, ""))
+	if err != nil {
+		return nil, packs.FailErr(err, "parse", packs.EnvAppPorts)
+	}
+	return ports.With(port), nil
 }
 
-func (a *App) Stage() map[string]string {
-	name, uri, limits := a.config()
+// Stage returns the env for staging the app. It fails if the identity,
+// service bindings or user-provided env of the app cannot be read, or if
+// the app ports are invalid.
+func (a *App) Stage() (map[string]string, error) {
+	id, uri, limits, err := a.config()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.ports(); err != nil {
+		return nil, err
+	}
+	services, err := a.vcapServices()
+	if err != nil {
+		return nil, err
+	}
+	userEnv, err := a.StagingEnv()
+	if err != nil {
+		return nil, packs.FailErr(err, "read staging env")
+	}
+	ip := a.envStr(packs.EnvAppIP, a.ip)
 
 	vcapApp, err := json.Marshal(&VCAPApplication{
-		ApplicationID:      a.id,
-		ApplicationName:    name,
+		ApplicationID:      id.AppGUID,
+		ApplicationName:    id.AppName,
 		ApplicationURIs:    []string{uri},
-		ApplicationVersion: a.version,
+		ApplicationVersion: id.Version,
 		Limits:             limits,
-		Name:               name,
-		SpaceID:            a.spaceID,
-		SpaceName:          fmt.Sprintf("%s-space", name),
+		Name:               id.AppName,
+		OrganizationID:     id.OrgGUID,
+		OrganizationName:   id.OrgName,
+		SpaceID:            id.SpaceGUID,
+		SpaceName:          id.SpaceName,
 		URIs:               []string{uri},
-		Version:            a.version,
+		Version:            id.Version,
 	})
 	if err != nil {
 		vcapApp = []byte("{}")
@@ -152,38 +243,73 @@ func (a *App) Stage() map[string]string {
 
 	appEnv := map[string]string{
 		"CF_INSTANCE_ADDR":        "",
-		"CF_INSTANCE_INTERNAL_IP": a.ip,
-		"CF_INSTANCE_IP":          a.ip,
+		"CF_INSTANCE_INTERNAL_IP": ip,
+		"CF_INSTANCE_IP":          ip,
 		"CF_INSTANCE_PORT":        "",
 		"CF_INSTANCE_PORTS":       "[]",
-		"CF_STACK":                "cflinuxfs2",
+		"CF_STACK":                a.Stack(),
 		"MEMORY_LIMIT":            fmt.Sprintf("%dm", limits["mem"]),
 		"VCAP_APPLICATION":        string(vcapApp),
-		"VCAP_SERVICES":           "{}",
+		"VCAP_SERVICES":           services,
 	}
-	a.envOverride(appEnv)
 
-	return mergeMaps(sysEnv, appEnv)
+	return a.layerEnv(sysEnv, appEnv, userEnv), nil
 }
 
-func (a *App) Launch() map[string]string {
-	name, uri, limits := a.config()
+// Launch returns the env for running the app. It fails if the identity,
+// service bindings or user-provided env of the app cannot be read, or if
+// the app ports are invalid.
+func (a *App) Launch() (map[string]string, error) {
+	id, uri, limits, err := a.config()
+	if err != nil {
+		return nil, err
+	}
+	services, err := a.vcapServices()
+	if err != nil {
+		return nil, err
+	}
+	userEnv, err := a.RunningEnv()
+	if err != nil {
+		return nil, packs.FailErr(err, "read running env")
+	}
+	ip := a.envStr(packs.EnvAppIP, a.ip)
+	ports, err := a.ports()
+	if err != nil {
+		return nil, err
+	}
+	port := strconv.FormatUint(uint64(ports[0]), 10)
+	index := strconv.FormatUint(uint64(id.InstanceIndex), 10)
+
+	type instancePort struct {
+		External uint `json:"external"`
+		Internal uint `json:"internal"`
+	}
+	var instancePorts []instancePort
+	for _, p := range ports {
+		instancePorts = append(instancePorts, instancePort{p, p})
+	}
+	instancePortsJSON, err := json.Marshal(instancePorts)
+	if err != nil {
+		instancePortsJSON = []byte("[]")
+	}
 
 	vcapApp, err := json.Marshal(&VCAPApplication{
-		ApplicationID:      a.id,
-		ApplicationName:    name,
+		ApplicationID:      id.AppGUID,
+		ApplicationName:    id.AppName,
 		ApplicationURIs:    []string{uri},
-		ApplicationVersion: a.version,
+		ApplicationVersion: id.Version,
 		Host:               "0.0.0.0",
 		InstanceID:         a.instanceID,
-		InstanceIndex:      uintPtr(0),
+		InstanceIndex:      uintPtr(id.InstanceIndex),
 		Limits:             limits,
-		Name:               name,
-		Port:               uintPtr(8080),
-		SpaceID:            a.spaceID,
-		SpaceName:          fmt.Sprintf("%s-space", name),
+		Name:               id.AppName,
+		OrganizationID:     id.OrgGUID,
+		OrganizationName:   id.OrgName,
+		Port:               uintPtr(ports[0]),
+		SpaceID:            id.SpaceGUID,
+		SpaceName:          id.SpaceName,
 		URIs:               []string{uri},
-		Version:            a.version,
+		Version:            id.Version,
 	})
 	if err != nil {
 		vcapApp = []byte("{}")
@@ -197,26 +323,26 @@ func (a *App) Launch() map[string]string {
 	}
 
 	appEnv := map[string]string{
-		"CF_INSTANCE_ADDR":        a.ip + ":8080",
+		"CF_INSTANCE_ADDR":        net.JoinHostPort(ip, port),
 		"CF_INSTANCE_GUID":        a.instanceID,
-		"CF_INSTANCE_INDEX":       "0",
-		"CF_INSTANCE_INTERNAL_IP": a.ip,
-		"CF_INSTANCE_IP":          a.ip,
-		"CF_INSTANCE_PORT":        "8080",
-		"CF_INSTANCE_PORTS":       `[{"external":8080,"internal":8080}]`,
+		"CF_INSTANCE_INDEX":       index,
+		"CF_INSTANCE_INTERNAL_IP": ip,
+		"CF_INSTANCE_IP":          ip,
+		"CF_INSTANCE_PORT":        port,
+		"CF_INSTANCE_PORTS":       string(instancePortsJSON),
+		"DEPS_DIR":                "/home/vcap/deps",
 		"INSTANCE_GUID":           a.instanceID,
-		"INSTANCE_INDEX":          "0",
+		"INSTANCE_INDEX":          index,
 		"MEMORY_LIMIT":            fmt.Sprintf("%dm", limits["mem"]),
-		"PORT":                    "8080",
+		"PORT":                    port,
 		"TMPDIR":                  "/home/vcap/tmp",
 		"VCAP_APP_HOST":           "0.0.0.0",
 		"VCAP_APPLICATION":        string(vcapApp),
-		"VCAP_APP_PORT":           "8080",
-		"VCAP_SERVICES":           "{}",
+		"VCAP_APP_PORT":           port,
+		"VCAP_SERVICES":           services,
 	}
-	a.envOverride(appEnv)
 
-	return mergeMaps(sysEnv, appEnv)
+	return a.layerEnv(sysEnv, appEnv, userEnv), nil
 }
 
 func (a *App) envStr(key, val string) string {
@@ -235,6 +361,17 @@ func (a *App) envInt(key string, val uint64) uint64 {
 	return val
 }
 
+// layerEnv merges the system env, the generated CF env, and the user env
+// from env var groups and the manifest, in order of increasing precedence.
+// Process env vars explicitly set for any generated or user-provided var
+// take precedence over all of them.
+func (a *App) layerEnv(sysEnv, appEnv, userEnv map[string]string) map[string]string {
+	a.envOverride(appEnv)
+	userEnv = mergeMaps(userEnv)
+	a.envOverride(userEnv)
+	return mergeMaps(sysEnv, appEnv, userEnv)
+}
+
 func (a *App) envOverride(m map[string]string) {
 	for k, v := range m {
 		m[k] = a.envStr(k, v)
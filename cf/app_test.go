@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
+	"os"
 	"os/exec"
 	"reflect"
 	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/sclevine/spec"
@@ -16,7 +19,11 @@ import (
 	"github.com/buildpack/packs/cf"
 )
 
-var memory = flag.Uint64("memory", 1024, "expected memory usage in mb")
+var (
+	memory = flag.Uint64("memory", 1024, "expected memory usage in mb")
+	cpu    = flag.Uint64("cpu", 0, "expected cpu quota in percent of one cpu")
+	stack  = flag.String("stack", cf.DefaultStack, "expected stack name")
+)
 
 type cmpMap []struct {
 	k, v2 string
@@ -26,6 +33,13 @@ type cmpMap []struct {
 func TestApp(t *testing.T) {
 	spec.Run(t, "#Stage", testStage)
 	spec.Run(t, "#Launch", testLaunch)
+	spec.Run(t, "#Identity", testIdentity)
+	spec.Run(t, "#Stack", testStack)
+	spec.Run(t, "#EnvGroups", testEnvGroups)
+	spec.Run(t, "#HealthCheck", testHealthCheck)
+	spec.Run(t, "#Services", testServices)
+	spec.Run(t, "#ProjectServiceBindings", testProjectServiceBindings)
+	spec.Run(t, "#InterpolateCredentials", testInterpolateCredentials)
 }
 
 func testStage(t *testing.T, when spec.G, it spec.S) {
@@ -43,7 +57,7 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 	})
 
 	it("should return the default staging env", func() {
-		env := app.Stage()
+		env := stage(t, app)
 
 		vcapApp, err := vcapAppExpect(env["VCAP_APPLICATION"])
 		if err != nil {
@@ -59,7 +73,7 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 			{"CF_INSTANCE_IP", "", hostIPCmp},
 			{"CF_INSTANCE_PORT", "", nil},
 			{"CF_INSTANCE_PORTS", "[]", nil},
-			{"CF_STACK", "cflinuxfs2", nil},
+			{"CF_STACK", *stack, nil},
 			{"HOME", "/home/vcap", nil},
 			{"LANG", "en_US.UTF-8", nil},
 			{"MEMORY_LIMIT", fmt.Sprintf("%dm", *memory), nil},
@@ -81,8 +95,9 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 			set(packs.EnvAppDisk, "10")
 			set(packs.EnvAppFds, "20")
 			set(packs.EnvAppMemory, "30")
+			set(packs.EnvAppCPU, "40")
 
-			env := app.Stage()
+			env := stage(t, app)
 
 			if mem := env["MEMORY_LIMIT"]; mem != "30m" {
 				t.Fatalf("Incorrect memory: %s", mem)
@@ -94,8 +109,9 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 			}
 			vcapApp.ApplicationName = "some-name"
 			vcapApp.ApplicationURIs = []string{"some-uri"}
-			vcapApp.Limits = map[string]uint64{"disk": 10, "fds": 20, "mem": 30}
+			vcapApp.Limits = map[string]uint64{"disk": 10, "fds": 20, "mem": 30, "cpu": 40}
 			vcapApp.Name = "some-name"
+			vcapApp.OrganizationName = "some-name-org"
 			vcapApp.SpaceName = "some-name-space"
 			vcapApp.URIs = []string{"some-uri"}
 			vcapAppJSON, err := json.Marshal(vcapApp)
@@ -114,7 +130,7 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 			set("CF_INSTANCE_PORTS", "some-ports")
 			set("MEMORY_LIMIT", "some-memory")
 
-			env := app.Stage()
+			env := stage(t, app)
 
 			expected := cmpMap{
 				{"CF_INSTANCE_IP", "some-ip", nil},
@@ -130,7 +146,7 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 		it("should use the name for the uri as well", func() {
 			set(packs.EnvAppName, "some-name")
 
-			env := app.Stage()
+			env := stage(t, app)
 
 			vcapApp, err := vcapAppExpect(env["VCAP_APPLICATION"])
 			if err != nil {
@@ -139,6 +155,7 @@ func testStage(t *testing.T, when spec.G, it spec.S) {
 			vcapApp.ApplicationName = "some-name"
 			vcapApp.ApplicationURIs = []string{"some-name.local"}
 			vcapApp.Name = "some-name"
+			vcapApp.OrganizationName = "some-name-org"
 			vcapApp.SpaceName = "some-name-space"
 			vcapApp.URIs = []string{"some-name.local"}
 			vcapAppJSON, err := json.Marshal(vcapApp)
@@ -165,7 +182,7 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 	})
 
 	it("should return the default launch env", func() {
-		env := app.Launch()
+		env := launch(t, app)
 		vcapApp, err := vcapAppExpect(env["VCAP_APPLICATION"])
 		if err != nil {
 			t.Fatalf("Error: %s\n", err)
@@ -186,6 +203,7 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 			{"CF_INSTANCE_IP", "", hostIPCmp},
 			{"CF_INSTANCE_PORT", "8080", nil},
 			{"CF_INSTANCE_PORTS", `[{"external":8080,"internal":8080}]`, nil},
+			{"DEPS_DIR", "/home/vcap/deps", nil},
 			{"HOME", "/home/vcap/app", nil},
 			{"INSTANCE_GUID", env["CF_INSTANCE_GUID"], nil},
 			{"INSTANCE_INDEX", "0", nil},
@@ -213,12 +231,25 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 			set(packs.EnvAppDisk, "10")
 			set(packs.EnvAppFds, "20")
 			set(packs.EnvAppMemory, "30")
+			set(packs.EnvAppCPU, "40")
+			set(packs.EnvAppIP, "fd00::1")
+			set(packs.EnvAppPort, "9090")
+			set(packs.EnvAppPorts, "9091,9090,9092")
 
-			env := app.Launch()
+			env := launch(t, app)
 
 			if mem := env["MEMORY_LIMIT"]; mem != "30m" {
 				t.Fatalf("Incorrect memory: %s", mem)
 			}
+			compare(t, env, cmpMap{
+				{"CF_INSTANCE_ADDR", "[fd00::1]:9090", nil},
+				{"CF_INSTANCE_INTERNAL_IP", "fd00::1", nil},
+				{"CF_INSTANCE_IP", "fd00::1", nil},
+				{"CF_INSTANCE_PORT", "9090", nil},
+				{"CF_INSTANCE_PORTS", `[{"external":9090,"internal":9090},{"external":9091,"internal":9091},{"external":9092,"internal":9092}]`, nil},
+				{"PORT", "9090", nil},
+				{"VCAP_APP_PORT", "9090", nil},
+			})
 
 			vcapApp, err := vcapAppExpect(env["VCAP_APPLICATION"])
 			if err != nil {
@@ -228,12 +259,13 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 			vcapApp.Host = "0.0.0.0"
 			vcapApp.InstanceID = env["CF_INSTANCE_GUID"]
 			vcapApp.InstanceIndex = uintPtr(0)
-			vcapApp.Port = uintPtr(8080)
+			vcapApp.Port = uintPtr(9090)
 
 			vcapApp.ApplicationName = "some-name"
 			vcapApp.ApplicationURIs = []string{"some-uri"}
-			vcapApp.Limits = map[string]uint64{"disk": 10, "fds": 20, "mem": 30}
+			vcapApp.Limits = map[string]uint64{"disk": 10, "fds": 20, "mem": 30, "cpu": 40}
 			vcapApp.Name = "some-name"
+			vcapApp.OrganizationName = "some-name-org"
 			vcapApp.SpaceName = "some-name-space"
 			vcapApp.URIs = []string{"some-uri"}
 
@@ -245,6 +277,28 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 		})
 	})
 
+	for _, tt := range []struct{ k, v string }{
+		{packs.EnvAppPort, "0"},
+		{packs.EnvAppPort, "65536"},
+		{packs.EnvAppPorts, "9091,some-port"},
+	} {
+		tt := tt
+		when(tt.k+" is invalid: "+tt.v, func() {
+			it("should fail to stage or launch with CodeInvalidArgs", func() {
+				set(tt.k, tt.v)
+				for _, f := range []func() (map[string]string, error){app.Stage, app.Launch} {
+					_, err := f()
+					if err, ok := err.(*packs.ErrorFail); !ok || err.Code != packs.CodeInvalidArgs {
+						t.Fatalf("Incorrect error: %#v\n", err)
+					}
+					if !strings.Contains(err.Error(), tt.k) {
+						t.Fatalf("Missing env var in error: %s\n", err)
+					}
+				}
+			})
+		})
+	}
+
 	when("buildpack env vars are set", func() {
 		it("should always override other values", func() {
 			set(packs.EnvAppMemory, "30")
@@ -253,7 +307,7 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 			set("CF_INSTANCE_INDEX", "some-index")
 			set("MEMORY_LIMIT", "some-memory")
 
-			env := app.Launch()
+			env := launch(t, app)
 
 			expected := cmpMap{
 				{"CF_INSTANCE_ADDR", "some-addr", nil},
@@ -269,7 +323,7 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 		it("should use the name for the uri as well", func() {
 			set(packs.EnvAppName, "some-name")
 
-			env := app.Launch()
+			env := launch(t, app)
 
 			vcapApp, err := vcapAppExpect(env["VCAP_APPLICATION"])
 			if err != nil {
@@ -284,6 +338,7 @@ func testLaunch(t *testing.T, when spec.G, it spec.S) {
 			vcapApp.ApplicationName = "some-name"
 			vcapApp.ApplicationURIs = []string{"some-name.local"}
 			vcapApp.Name = "some-name"
+			vcapApp.OrganizationName = "some-name-org"
 			vcapApp.SpaceName = "some-name-space"
 			vcapApp.URIs = []string{"some-name.local"}
 
@@ -318,11 +373,23 @@ func uuidCmp(t *testing.T, uuid, _ string) {
 
 func hostIPCmp(t *testing.T, ip, suffix string) {
 	t.Helper()
-	out, err := exec.Command("hostname", "-i").Output()
+	out, err := exec.Command("hostname", "-I").Output()
 	if err != nil {
 		t.Fatalf("Error: %s\n", err)
 	}
-	if expected := strings.TrimSpace(string(out)) + suffix; ip != expected {
+	expected := "127.0.0.1"
+	for _, addr := range strings.Fields(string(out)) {
+		if net.ParseIP(addr).To4() != nil {
+			expected = addr
+			break
+		} else if expected == "127.0.0.1" && !net.ParseIP(addr).IsLinkLocalUnicast() {
+			expected = addr
+		}
+	}
+	if suffix != "" {
+		expected = net.JoinHostPort(expected, strings.TrimPrefix(suffix, ":"))
+	}
+	if ip != expected {
 		t.Fatalf("Mismatched IP: %s != %s\n", ip, expected)
 	}
 }
@@ -340,13 +407,26 @@ func vcapAppExpect(vcapAppJSON string) (cf.VCAPApplication, error) {
 	if err != nil {
 		return cf.VCAPApplication{}, err
 	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs("/home/vcap", &fs); os.IsNotExist(err) {
+		err = syscall.Statfs("/", &fs)
+	}
+	if err != nil {
+		return cf.VCAPApplication{}, err
+	}
+	limits := map[string]uint64{"disk": fs.Blocks * uint64(fs.Bsize) / 1024 / 1024, "fds": fds, "mem": *memory}
+	if *cpu > 0 {
+		limits["cpu"] = *cpu
+	}
 	return cf.VCAPApplication{
 		ApplicationID:      vcapApp.ApplicationID,
 		ApplicationName:    "app",
 		ApplicationURIs:    []string{"app.local"},
 		ApplicationVersion: vcapApp.ApplicationVersion,
-		Limits:             map[string]uint64{"disk": 1024, "fds": fds, "mem": *memory},
+		Limits:             limits,
 		Name:               "app",
+		OrganizationID:     vcapApp.OrganizationID,
+		OrganizationName:   "app-org",
 		SpaceID:            vcapApp.SpaceID,
 		SpaceName:          "app-space",
 		URIs:               []string{"app.local"},
@@ -403,3 +483,21 @@ func env() (env func(string) (string, bool), set func(k, v string)) {
 			m[k] = v
 		}
 }
+
+func stage(t *testing.T, app *cf.App) map[string]string {
+	t.Helper()
+	env, err := app.Stage()
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	return env
+}
+
+func launch(t *testing.T, app *cf.App) map[string]string {
+	t.Helper()
+	env, err := app.Launch()
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	return env
+}
@@ -0,0 +1,243 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/buildpack/packs"
+)
+
+const (
+	serviceBindingRoot        = "SERVICE_BINDING_ROOT"
+	defaultServiceBindingRoot = "/home/vcap/bindings"
+	bindingType               = "type"
+	bindingProvider           = "provider"
+	projectionFile            = ".vcap_services.json"
+)
+
+// ProjectServiceBindings makes the services in env available in both forms.
+// Unless SERVICE_BINDING_ROOT already holds bindings that were not projected
+// by packs, it is rebuilt from VCAP_SERVICES with one binding directory per
+// service and one file per credential. The projection also keeps a copy of
+// VCAP_SERVICES so that Services can read it back without losing fields.
+// Credentials are written as they appear in VCAP_SERVICES, so resolved
+// credhub-ref credentials must not be projected.
+func (a *App) ProjectServiceBindings(env map[string]string) error {
+	root := a.envStr(serviceBindingRoot, defaultServiceBindingRoot)
+	projected := isProjection(root)
+	if files, err := ioutil.ReadDir(root); err == nil && len(files) > 0 && !projected {
+		env[serviceBindingRoot] = root
+		return nil
+	}
+	var services VCAPServices
+	if err := json.Unmarshal([]byte(env["VCAP_SERVICES"]), &services); err != nil {
+		return packs.FailErr(err, "parse VCAP_SERVICES")
+	}
+	if len(services) == 0 {
+		if projected {
+			if err := os.RemoveAll(root); err != nil {
+				return packs.FailErr(err, "remove service bindings from", root)
+			}
+		}
+		return nil
+	}
+	if err := writeBindings(root, env["VCAP_SERVICES"], services); err != nil {
+		return packs.FailErr(err, "write service bindings to", root)
+	}
+	env[serviceBindingRoot] = root
+	return nil
+}
+
+// ProvideServices projects the services in env to SERVICE_BINDING_ROOT and
+// then, if source is not nil, resolves the credhub-ref credentials in
+// VCAP_SERVICES. Bindings are projected first so that the resolved
+// credentials are never written to disk.
+func (a *App) ProvideServices(env map[string]string, source CredentialSource) error {
+	if err := a.ProjectServiceBindings(env); err != nil {
+		return err
+	}
+	if source == nil {
+		return nil
+	}
+	services, err := InterpolateCredentials(env["VCAP_SERVICES"], source)
+	if err != nil {
+		return err
+	}
+	env["VCAP_SERVICES"] = services
+	return nil
+}
+
+// writeBindings builds the bindings in a temporary directory next to root
+// and moves it into place, replacing any previous projection, so that root
+// never holds a partial projection.
+func writeBindings(root, vcapServices string, services VCAPServices) error {
+	if err := os.MkdirAll(filepath.Dir(root), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := ioutil.TempDir(filepath.Dir(root), "."+filepath.Base(root)+".")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := os.Chmod(tmpDir, 0755); err != nil {
+		return err
+	}
+	if err := writeBindingsDir(tmpDir, services); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, projectionFile), []byte(vcapServices), 0600); err != nil {
+		return err
+	}
+	if isProjection(root) {
+		oldDir, err := ioutil.TempDir(filepath.Dir(root), "."+filepath.Base(root)+".")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(oldDir)
+		if err := os.Rename(root, filepath.Join(oldDir, "bindings")); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmpDir, root)
+}
+
+func writeBindingsDir(dir string, services VCAPServices) error {
+	var labels []string
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	bindings := map[string]string{}
+	for _, label := range labels {
+		for _, s := range services[label] {
+			name, err := bindingName(s.Name)
+			if err != nil {
+				return err
+			}
+			if other, ok := bindings[name]; ok {
+				return fmt.Errorf("services '%s' and '%s/%s' both have binding name '%s'", other, label, s.Name, name)
+			}
+			bindings[name] = label + "/" + s.Name
+			files, err := bindingFiles(label, s)
+			if err != nil {
+				return packs.FailErr(err, "project service", label+"/"+s.Name)
+			}
+			if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+				return err
+			}
+			for file, contents := range files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name, file), []byte(contents), 0600); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bindingFiles returns the contents of each file in the binding for s.
+func bindingFiles(label string, s VCAPService) (map[string]string, error) {
+	files := map[string]string{bindingType: label}
+	if s.Provider != nil {
+		files[bindingProvider] = *s.Provider
+	}
+	for k, v := range s.Credentials {
+		file, err := bindingName(k)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := files[file]; ok {
+			return nil, fmt.Errorf("credential '%s' conflicts with binding file '%s'", k, file)
+		}
+		if str, ok := v.(string); ok {
+			files[file] = str
+			continue
+		}
+		out, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		files[file] = string(out)
+	}
+	return files, nil
+}
+
+// isProjection returns true if dir holds bindings projected by packs.
+func isProjection(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, projectionFile))
+	return err == nil
+}
+
+// readProjection reads the services from a projection written by packs.
+func readProjection(dir string) ([]VCAPService, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, projectionFile))
+	if err != nil {
+		return nil, packs.FailErr(err, "read service bindings from", dir)
+	}
+	var services VCAPServices
+	if err := json.Unmarshal(contents, &services); err != nil {
+		return nil, packs.FailErr(err, "parse service bindings from", dir)
+	}
+	var labels []string
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	var out []VCAPService
+	for _, label := range labels {
+		for _, s := range services[label] {
+			if s.Label == "" {
+				s.Label = label
+			}
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// readProjectedBinding reads a binding with a type file and one file per
+// credential, skipping hidden entries such as Kubernetes' ..data links.
+func readProjectedBinding(dir string) (VCAPService, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return VCAPService{}, packs.FailErr(err, "read binding", dir)
+	}
+	service := VCAPService{
+		Name:        filepath.Base(dir),
+		Credentials: map[string]interface{}{},
+	}
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return VCAPService{}, packs.FailErr(err, "read", name, "for binding", service.Name)
+		}
+		switch value := string(contents); name {
+		case bindingType:
+			service.Label = strings.TrimSpace(value)
+			service.Tags = []string{service.Label}
+		case bindingProvider:
+			provider := strings.TrimSpace(value)
+			service.Provider = &provider
+		default:
+			service.Credentials[name] = value
+		}
+	}
+	return service, nil
+}
+
+func bindingName(name string) (string, error) {
+	switch name {
+	case "", ".", "..":
+		return "", fmt.Errorf("invalid binding name: '%s'", name)
+	}
+	return strings.Replace(name, string(filepath.Separator), "-", -1), nil
+}
@@ -3,22 +3,26 @@ package main
 import (
 	"crypto/sha1"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
-	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 
 	bal "code.cloudfoundry.org/buildpackapplifecycle"
 	"code.cloudfoundry.org/cli/cf/appfiles"
 
+	"github.com/buildpack/lifecycle/img"
 	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cache"
 	"github.com/buildpack/packs/cf"
+	"github.com/buildpack/packs/droplet"
+	"github.com/buildpack/packs/procfile"
 )
 
 var (
@@ -35,13 +39,52 @@ var (
 	buildpacksDir  string
 	buildpackOrder []string
 	skipDetect     bool
+	detectOnly     bool
+	limits         packs.BuildLimits
+	cacheImage     string
+	servicesFile   string
+	stagingEnv     string
+
+	// rootless builds run as the current user and skip all chown calls
+	rootless   bool
+	credential *syscall.Credential
+
+	healthCheck cf.HealthCheck
+
+	config      bal.LifecycleBuilderConfig
+	builderArgs []string
 )
 
+// packsFlags are handled by this builder and not passed to /lifecycle/builder.
+var packsFlags = map[string]bool{
+	"detect-only":   true,
+	"timeout":       true,
+	"phaseTimeouts": true,
+	"rlimitCPU":     true,
+	"rlimitNproc":   true,
+	"rlimitFsize":   true,
+	"cacheImage":    true,
+	"services":      true,
+	"stagingEnv":    true,
+}
+
 func main() {
-	config := bal.NewLifecycleBuilderConfig(nil, false, false)
+	config = bal.NewLifecycleBuilderConfig(nil, false, false)
+	config.BoolVar(&detectOnly, "detect-only", false, "report which buildpacks detect the app without building it")
+	if err := packs.InputBuildLimits(config.FlagSet, &limits, "detect", "build"); err != nil {
+		packs.Exit(err)
+	}
+	config.StringVar(&cacheImage, "cacheImage", os.Getenv(packs.EnvCacheImage), "image repository used to store the build cache")
+	config.StringVar(&servicesFile, "services", os.Getenv(packs.EnvServicesFile), "YAML file describing bound services")
+	packs.InputStagingEnv(config.FlagSet, &stagingEnv)
 	if err := config.Parse(os.Args[1:]); err != nil {
 		packs.Exit(packs.FailErrCode(err, packs.CodeInvalidArgs, "parse arguments"))
 	}
+	config.Visit(func(f *flag.Flag) {
+		if !packsFlags[f.Name] {
+			builderArgs = append(builderArgs, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
 
 	buildDir = config.BuildDir()
 	cacheDir = config.BuildArtifactsCacheDir()
@@ -53,6 +96,8 @@ func main() {
 	buildpackOrder = config.BuildpackOrder()
 	skipDetect = config.SkipDetect()
 
+	rootless = os.Geteuid() != 0
+
 	appName = os.Getenv(packs.EnvAppName)
 	appZip = os.Getenv(packs.EnvAppZip)
 	appDir = os.Getenv(packs.EnvAppDir)
@@ -60,6 +105,12 @@ func main() {
 	if wd, err := os.Getwd(); appDir == "" && err == nil {
 		appDir = wd
 	}
+	if servicesFile != "" {
+		os.Setenv(packs.EnvServicesFile, servicesFile)
+	}
+	if stagingEnv != "" {
+		os.Setenv(packs.EnvStagingEnv, stagingEnv)
+	}
 
 	packs.Exit(stage())
 }
@@ -91,7 +142,14 @@ func stage() error {
 		return packs.FailCode(packs.CodeInvalidArgs, "parse app directory")
 	}
 
-	if _, err := os.Stat(cachePath); err == nil {
+	if cacheImage != "" {
+		if err := pullCache(); err != nil {
+			log.Printf("Warning: using empty cache: %s\n", err)
+			if err := os.RemoveAll(cacheDir); err != nil {
+				return packs.FailErr(err, "clear cache")
+			}
+		}
+	} else if _, err := os.Stat(cachePath); err == nil {
 		if err := untar(cachePath, cacheDir); err != nil {
 			return packs.FailErr(err, "extract cache")
 		}
@@ -115,39 +173,172 @@ func stage() error {
 		extraArgs = append(extraArgs, "-buildpackOrder", names)
 	}
 
-	uid, gid, err := userLookup("vcap")
-	if err != nil {
-		return packs.FailErr(err, "determine vcap UID/GID")
-	}
-	if err := setupStdFds(); err != nil {
-		return packs.FailErr(err, "adjust fd ownership")
+	if !rootless {
+		uid, gid, err := packs.LookupUser("vcap")
+		if err != nil {
+			return packs.FailErr(err, "determine vcap UID/GID")
+		}
+		credential = &syscall.Credential{Uid: uid, Gid: gid}
+		if err := packs.ChownStdFds("vcap"); err != nil {
+			return packs.FailErr(err, "adjust fd ownership")
+		}
 	}
 	if err := setupEnv(); err != nil {
 		return packs.FailErrCode(err, packs.CodeInvalidEnv, "setup env")
 	}
+	if detectOnly {
+		results, err := detect()
+		if err != nil {
+			return err
+		}
+		out, err := json.Marshal(results)
+		if err != nil {
+			return packs.FailErr(err, "encode detection results")
+		}
+		fmt.Println(string(out))
+		if !packs.Detected(results) {
+			return packs.FailCode(packs.CodeFailedDetect, "detect")
+		}
+		return nil
+	}
 
-	cmd := exec.Command("/lifecycle/builder", append(os.Args[1:], extraArgs...)...)
+	procfileTypes, err := checkProcfile()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("/lifecycle/builder", append(builderArgs, extraArgs...)...)
 	cmd.Dir = buildDir
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Credential: &syscall.Credential{Uid: uid, Gid: gid},
+		Credential: credential,
 	}
-	if err := cmd.Run(); err != nil {
+	if err := limits.Run(cmd, "build"); err != nil {
+		if err, ok := err.(*packs.ErrorFail); ok {
+			return err
+		}
+		if exitStatus(err) == bal.DETECT_FAIL_CODE {
+			if results, err := detect(); err == nil {
+				for _, r := range results {
+					log.Printf("Tried buildpack %s: detected=%t %s\n", r.Name, r.Detected, r.Output)
+				}
+			}
+			return packs.FailErrCode(err, packs.CodeFailedDetect, "detect")
+		}
 		return packs.FailErrCode(err, packs.CodeFailedBuild, "build")
 	}
+	dropletSHA, dropletSize, err := droplet.Digest(dropletPath)
+	if err != nil {
+		return packs.FailErr(err, "digest droplet")
+	}
 	if err := setKeyJSON(metadataPath, "pack_metadata", cf.PackMetadata{
 		App: packs.AppMetadata{
 			Name: appName,
 			SHA:  appVersion,
 		},
+		Stack:         os.Getenv("CF_STACK"),
+		HealthCheck:   &healthCheck,
+		DropletSHA256: dropletSHA,
+		DropletSize:   dropletSize,
 	}); err != nil {
 		return packs.FailErr(err, "write metadata")
 	}
+	if err := recordProcessTypes(metadataPath, procfileTypes); err != nil {
+		return packs.FailErr(err, "write metadata")
+	}
+	if cacheImage != "" {
+		if err := pushCache(); err != nil {
+			log.Printf("Warning: failed to save cache: %s\n", err)
+		}
+	}
 	return nil
 }
 
+// checkProcfile returns the process types in the Procfile of the app, so
+// that the build fails early if the Procfile is invalid.
+func checkProcfile() (map[string]string, error) {
+	processTypes, err := procfile.Read(filepath.Join(buildDir, "Procfile"))
+	if err != nil {
+		return nil, packs.FailErrCode(err, packs.CodeFailedBuild, "parse Procfile")
+	}
+	if len(processTypes) > 0 {
+		log.Printf("Procfile declares types -> %s\n", strings.Join(procfile.Types(processTypes), ", "))
+	}
+	return processTypes, nil
+}
+
+// recordProcessTypes overrides the process types detected by the
+// buildpacks in the metadata at path with those declared in the Procfile.
+func recordProcessTypes(path string, procfileTypes map[string]string) error {
+	if len(procfileTypes) == 0 {
+		return nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return packs.FailErr(err, "read metadata")
+	}
+	var metadata cf.DropletMetadata
+	if err := json.Unmarshal(contents, &metadata); err != nil {
+		return packs.FailErr(err, "decode JSON at", path)
+	}
+	processTypes := map[string]string{}
+	for name, command := range metadata.ProcessTypes {
+		processTypes[name] = command
+	}
+	for name, command := range procfileTypes {
+		processTypes[name] = command
+	}
+	return setKeyJSON(path, "process_types", processTypes)
+}
+
+func pullCache() error {
+	store, err := img.NewRegistry(cacheImage)
+	if err != nil {
+		return packs.FailErr(err, "access", cacheImage)
+	}
+	return cache.Pull(store, cacheDir)
+}
+
+func pushCache() error {
+	store, err := img.NewRegistry(cacheImage)
+	if err != nil {
+		return packs.FailErr(err, "access", cacheImage)
+	}
+	return cache.Push(store, cacheDir)
+}
+
+func detect() ([]packs.DetectResult, error) {
+	names := buildpackOrder
+	if strings.Join(names, "") == "" {
+		list, err := reduceJSON(filepath.Join(buildpacksDir, "config.json"), "name")
+		if err != nil {
+			return nil, packs.FailErr(err, "determine buildpack names")
+		}
+		names = strings.Split(list, ",")
+	}
+	var buildpacks []packs.Buildpack
+	for _, name := range names {
+		buildpacks = append(buildpacks, packs.Buildpack{Name: name, Dir: config.BuildpackPath(name)})
+	}
+	return packs.Detect(buildDir, buildpacks, func(cmd *exec.Cmd) error {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: credential,
+		}
+		return limits.Run(cmd, "detect")
+	}), nil
+}
+
+func exitStatus(err error) int {
+	if err, ok := err.(*exec.ExitError); ok {
+		if status, ok := err.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
 func copyAppDir(src, dst string) error {
 	copier := appfiles.ApplicationFiles{}
 	files, err := copier.AppFilesInDir(src)
@@ -195,6 +386,9 @@ func vcapDir(dirs ...string) error {
 		if err := os.MkdirAll(dir, 0777); err != nil {
 			return packs.FailErr(err, "make directory", dir)
 		}
+		if rootless {
+			continue
+		}
 		if _, err := packs.Run("chown", "vcap:vcap", dir); err != nil {
 			return packs.FailErr(err, "chown", dir, "to vcap:vcap")
 		}
@@ -207,6 +401,9 @@ func vcapDirAll(dirs ...string) error {
 		if err := os.MkdirAll(dir, 0777); err != nil {
 			return packs.FailErr(err, "make directory", dir)
 		}
+		if rootless {
+			continue
+		}
 		if _, err := packs.Run("chown", "-R", "vcap:vcap", dir); err != nil {
 			return packs.FailErr(err, "recursively chown", dir, "to", "vcap:vcap")
 		}
@@ -304,7 +501,15 @@ func setupEnv() error {
 	if err != nil {
 		return packs.FailErr(err, "build app env")
 	}
-	for k, v := range app.Stage() {
+	app.Dir = buildDir
+	env, err := app.Stage()
+	if err != nil {
+		return packs.FailErr(err, "build app env")
+	}
+	if healthCheck, err = app.HealthCheck(); err != nil {
+		return packs.FailErr(err, "read health check")
+	}
+	for k, v := range env {
 		err := os.Setenv(k, v)
 		if err != nil {
 			return packs.FailErr(err, "set app env")
@@ -313,16 +518,6 @@ func setupEnv() error {
 	return nil
 }
 
-func setupStdFds() error {
-	cmd := exec.Command("chown", "vcap", "/dev/stdout", "/dev/stderr")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return packs.FailErr(err, "fix permissions of stdout and stderr")
-	}
-	return nil
-}
-
 func unzip(zip, dst string) error {
 	if err := os.MkdirAll(dst, 0777); err != nil {
 		return packs.FailErr(err, "ensure directory", dst)
@@ -342,19 +537,3 @@ func untar(tar, dst string) error {
 	}
 	return nil
 }
-
-func userLookup(u string) (uid, gid uint32, err error) {
-	usr, err := user.Lookup(u)
-	if err != nil {
-		return 0, 0, packs.FailErr(err, "find user", u)
-	}
-	uid64, err := strconv.ParseUint(usr.Uid, 10, 32)
-	if err != nil {
-		return 0, 0, packs.FailErr(err, "parse uid", usr.Uid)
-	}
-	gid64, err := strconv.ParseUint(usr.Gid, 10, 32)
-	if err != nil {
-		return 0, 0, packs.FailErr(err, "parse gid", usr.Gid)
-	}
-	return uint32(uid64), uint32(gid64), nil
-}
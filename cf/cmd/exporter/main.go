@@ -1,17 +1,30 @@
 package main
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"encoding/json"
 	"flag"
+	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 
 	"github.com/buildpack/lifecycle/img"
 	"github.com/buildpack/packs"
 	"github.com/buildpack/packs/cf"
+	"github.com/buildpack/packs/droplet"
+)
+
+const (
+	dropletRoot     = "home/vcap"
+	healthCheckPath = "/packs/healthcheck"
 )
 
 var (
@@ -21,6 +34,8 @@ var (
 	stackName    string
 	useDaemon    bool
 	useHelpers   bool
+	port         uint
+	ports        packs.Ports
 )
 
 func init() {
@@ -29,12 +44,15 @@ func init() {
 	packs.InputStackName(&stackName)
 	packs.InputUseDaemon(&useDaemon)
 	packs.InputUseHelpers(&useHelpers)
+	if err := packs.InputAppPorts(&port, &ports); err != nil {
+		packs.Exit(err)
+	}
 }
 
 func main() {
 	flag.Parse()
 	repoName = flag.Arg(0)
-	if flag.NArg() != 1 || repoName == "" || stackName == "" || (metadataPath != "" && dropletPath == "") {
+	if flag.NArg() != 1 || repoName == "" || (metadataPath != "" && dropletPath == "") {
 		packs.Exit(packs.FailCode(packs.CodeInvalidArgs, "parse arguments"))
 	}
 	packs.Exit(export())
@@ -42,7 +60,7 @@ func main() {
 
 func export() error {
 	if useHelpers {
-		if err := img.SetupCredHelpers(repoName, stackName); err != nil {
+		if err := img.SetupCredHelpers(repoName); err != nil {
 			return packs.FailErr(err, "setup credential helpers")
 		}
 	}
@@ -56,6 +74,42 @@ func export() error {
 		return packs.FailErr(err, "access", repoName)
 	}
 
+	var (
+		repoImage       v1.Image
+		metadata        packs.BuildMetadata
+		dropletMetadata cf.DropletMetadata
+	)
+	if dropletPath != "" {
+		if metadataPath != "" {
+			if dropletMetadata, err = readDropletMetadata(metadataPath); err != nil {
+				return packs.FailErr(err, "get droplet metadata")
+			}
+			metadata.Stack = dropletMetadata.PackMetadata.Stack
+		}
+	} else {
+		if repoImage, err = repoStore.Image(); err != nil {
+			return packs.FailErr(err, "get image for", repoName)
+		}
+		if metadata.Stack, err = imageStack(repoImage); err != nil {
+			return packs.FailErr(err, "get stack for", repoName)
+		}
+	}
+	if stackName == "" {
+		stack := metadata.Stack
+		if stack == "" {
+			stack = cf.DefaultStack
+			if id := os.Getenv(packs.EnvStackID); id != "" {
+				stack = id
+			}
+		}
+		stackName = cf.RunImage(stack)
+	}
+	if useHelpers {
+		if err := img.SetupCredHelpers(stackName); err != nil {
+			return packs.FailErr(err, "setup credential helpers")
+		}
+	}
+
 	stackStore, err := img.NewRegistry(stackName)
 	if err != nil {
 		return packs.FailErr(err, "access", stackName)
@@ -65,16 +119,14 @@ func export() error {
 		return packs.FailErr(err, "get image for", stackName)
 	}
 
-	var (
-		repoImage v1.Image
-		metadata  packs.BuildMetadata
-	)
+	var healthCheck *cf.HealthCheck
 	if dropletPath != "" {
 		if metadataPath != "" {
-			var err error
-			metadata.App, metadata.Buildpacks, err = readDropletMetadata(metadataPath)
-			if err != nil {
-				return packs.FailErr(err, "get droplet metadata")
+			metadata.App = dropletMetadata.PackMetadata.App
+			metadata.Buildpacks = dropletMetadata.Buildpacks()
+			healthCheck = dropletMetadata.PackMetadata.HealthCheck
+			if err := dropletMetadata.PackMetadata.VerifyDroplet(dropletPath); err != nil {
+				return packs.FailErrCode(err, packs.CodeFailedVerify, "verify", dropletPath)
 			}
 		}
 		layer, err := dropletToLayer(dropletPath)
@@ -86,11 +138,15 @@ func export() error {
 		if err != nil {
 			return packs.FailErr(err, "append droplet to", stackName)
 		}
-	} else {
-		repoImage, err = repoStore.Image()
-		if err != nil {
-			return packs.FailErr(err, "get image for", repoName)
+		if port == 0 {
+			port = cf.DefaultPort
 		}
+		if healthCheck != nil {
+			if repoImage, err = setHealthCheck(repoImage, *healthCheck); err != nil {
+				return packs.FailErr(err, "configure health check for", repoName)
+			}
+		}
+	} else {
 		repoImage, err = img.Rebase(repoImage, stackImage, func(labels map[string]string) (v1.Image, error) {
 			if err := json.Unmarshal([]byte(labels[packs.BuildLabel]), &metadata); err != nil {
 				return nil, err
@@ -105,6 +161,25 @@ func export() error {
 			return packs.FailErr(err, "rebase", repoName, "on", stackName)
 		}
 	}
+	if port != 0 || len(ports) > 0 {
+		if port == 0 {
+			port = cf.DefaultPort
+		}
+		repoImage, err = exposePorts(repoImage, ports.With(port))
+		if err != nil {
+			return packs.FailErr(err, "expose ports for", repoName)
+		}
+	}
+	stackConfig, err := stackImage.ConfigFile()
+	if err != nil {
+		return packs.FailErr(err, "get config for", stackName)
+	}
+	if runStack := stackConfig.Config.Labels[packs.StackLabel]; runStack != "" {
+		if metadata.Stack != "" && metadata.Stack != runStack {
+			return packs.FailCode(packs.CodeInvalidArgs, "use", metadata.Stack, "app with", runStack, "stack", stackName)
+		}
+		metadata.Stack = runStack
+	}
 	stackDigest, err := stackImage.Digest()
 	if err != nil {
 		return packs.FailErr(err, "get digest for", stackName)
@@ -125,41 +200,190 @@ func export() error {
 	return nil
 }
 
-func readDropletMetadata(path string) (packs.AppMetadata, []packs.BuildpackMetadata, error) {
+// imageStack returns the stack recorded in the build metadata of image.
+func imageStack(image v1.Image) (string, error) {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return "", err
+	}
+	label := configFile.Config.Labels[packs.BuildLabel]
+	if label == "" {
+		return "", nil
+	}
+	var metadata packs.BuildMetadata
+	if err := json.Unmarshal([]byte(label), &metadata); err != nil {
+		return "", err
+	}
+	return metadata.Stack, nil
+}
+
+// exposePorts sets the exposed ports of image and configures the launcher to
+// use the same ports.
+func exposePorts(image v1.Image, ports packs.Ports) (v1.Image, error) {
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.ExposedPorts = map[string]struct{}{}
+	for _, p := range ports {
+		config.ExposedPorts[strconv.FormatUint(uint64(p), 10)+"/tcp"] = struct{}{}
+	}
+	var env []string
+	for _, e := range config.Env {
+		if !strings.HasPrefix(e, packs.EnvAppPort+"=") && !strings.HasPrefix(e, packs.EnvAppPorts+"=") {
+			env = append(env, e)
+		}
+	}
+	additional := ports[1:]
+	config.Env = append(env,
+		packs.EnvAppPort+"="+strconv.FormatUint(uint64(ports[0]), 10),
+		packs.EnvAppPorts+"="+additional.String(),
+	)
+	return mutate.Config(image, config)
+}
+
+// setHealthCheck configures the image to run the health check with the
+// same interval and start period as the run image.
+func setHealthCheck(image v1.Image, hc cf.HealthCheck) (v1.Image, error) {
+	if err := hc.Validate(); err != nil {
+		return nil, err
+	}
+	configFile, err := image.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config := *configFile.Config.DeepCopy()
+	if hc.Type == cf.HealthCheckProcess {
+		config.Healthcheck = &v1.HealthConfig{Test: []string{"NONE"}}
+		return mutate.Config(image, config)
+	}
+	test := []string{"CMD", healthCheckPath, "-type", hc.Type}
+	if hc.Endpoint != "" {
+		test = append(test, "-endpoint", hc.Endpoint)
+	}
+	timeout := cf.DefaultHealthCheckTimeout
+	if hc.Timeout > 0 {
+		timeout = time.Duration(hc.Timeout) * time.Second
+		test = append(test, "-timeout", strconv.Itoa(hc.Timeout))
+	}
+	config.Healthcheck = &v1.HealthConfig{
+		Test:        test,
+		Interval:    30 * time.Second,
+		Timeout:     timeout + time.Second,
+		StartPeriod: 60 * time.Second,
+		Retries:     1,
+	}
+	return mutate.Config(image, config)
+}
+
+func readDropletMetadata(path string) (cf.DropletMetadata, error) {
+	var metadata cf.DropletMetadata
 	f, err := os.Open(path)
 	if err != nil {
-		return packs.AppMetadata{}, nil, packs.FailErr(err, "failed to open", path)
+		return metadata, packs.FailErr(err, "failed to open", path)
 	}
 	defer f.Close()
-	var metadata cf.DropletMetadata
 	if err := json.NewDecoder(f).Decode(&metadata); err != nil {
-		return packs.AppMetadata{}, nil, packs.FailErr(err, "failed to decode", path)
+		return metadata, packs.FailErr(err, "failed to decode", path)
 	}
-	return metadata.PackMetadata.App, metadata.Buildpacks(), nil
+	return metadata, nil
 }
 
 func dropletToLayer(dropletPath string) (layer string, err error) {
-	tmpDir, err := ioutil.TempDir("", "pack.export.layer")
+	vcapUID, vcapGID, err := packs.LookupUser("vcap")
+	if err != nil {
+		return "", packs.FailErr(err, "determine vcap UID/GID")
+	}
+	uid, gid := int(vcapUID), int(vcapGID)
+	in, err := os.Open(dropletPath)
+	if err != nil {
+		return "", packs.FailErr(err, "open", dropletPath)
+	}
+	defer in.Close()
+	gzr, err := gzip.NewReader(in)
 	if err != nil {
-		return "", packs.FailErr(err, "create temp directory")
+		return "", packs.FailErr(err, "decompress", dropletPath)
 	}
-	defer os.RemoveAll(tmpDir)
+	defer gzr.Close()
 
-	layer = tmpDir + ".tgz"
-	dropletRoot := filepath.Join(tmpDir, "home", "vcap")
+	out, err := ioutil.TempFile("", "pack.export.layer")
+	if err != nil {
+		return "", packs.FailErr(err, "create temp file")
+	}
+	defer out.Close()
+	layer = out.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(layer)
+		}
+	}()
 
-	if err := os.MkdirAll(dropletRoot, 0777); err != nil {
-		return "", packs.FailErr(err, "setup droplet directory")
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+	if err := writeDirs(tw, uid, gid); err != nil {
+		return "", packs.FailErr(err, "write droplet directory to", layer)
 	}
-	if _, err := packs.Run("tar", "-C", dropletRoot, "-xzf", dropletPath); err != nil {
-		return "", packs.FailErr(err, "untar", dropletPath, "to", dropletRoot)
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", packs.FailErr(err, "read", dropletPath)
+		}
+		if err := droplet.CheckHeader(hdr); err != nil {
+			return "", packs.FailErrCode(err, packs.CodeFailedVerify, "verify", dropletPath)
+		}
+		name := path.Clean(hdr.Name)
+		if name == "." || name == "/" {
+			continue
+		}
+		hdr.Name = path.Join(dropletRoot, name)
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = path.Join(dropletRoot, path.Clean(hdr.Linkname))
+		}
+		hdr.Uid, hdr.Gid = uid, gid
+		hdr.Uname, hdr.Gname = "vcap", "vcap"
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", packs.FailErr(err, "write", hdr.Name, "to", layer)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return "", packs.FailErr(err, "write", hdr.Name, "to", layer)
+		}
 	}
-	if _, err := packs.Run("chown", "-R", "vcap:vcap", dropletRoot); err != nil {
-		return "", packs.FailErr(err, "recursively chown", dropletRoot, "to", "vcap:vcap")
+	if err := tw.Close(); err != nil {
+		return "", packs.FailErr(err, "write", layer)
 	}
-	if _, err := packs.Run("tar", "-C", tmpDir, "-czf", layer, "home"); err != nil {
-		defer os.Remove(layer)
-		return "", packs.FailErr(err, "tar", tmpDir, "to", layer)
+	if err := gzw.Close(); err != nil {
+		return "", packs.FailErr(err, "compress", layer)
 	}
 	return layer, nil
 }
+
+// writeDirs adds /home owned by root and /home/vcap owned by vcap, so
+// that the layer has the right ownership without running chown.
+func writeDirs(tw *tar.Writer, uid, gid int) error {
+	now := time.Now()
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "home/",
+		Mode:     0755,
+		ModTime:  now,
+	}); err != nil {
+		return err
+	}
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     dropletRoot + "/",
+		Mode:     0755,
+		Uid:      uid,
+		Gid:      gid,
+		Uname:    "vcap",
+		Gname:    "vcap",
+		ModTime:  now,
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"strconv"
+
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cf"
+)
+
+const appDir = "/home/vcap/app"
+
+var (
+	healthCheck  cf.HealthCheck
+	metadataPath string
+	port         uint
+)
+
+func init() {
+	packs.InputMetadataPath(&metadataPath)
+	flag.StringVar(&healthCheck.Type, "type", "", "health check type: port, http or process (default from manifest.yml or droplet metadata)")
+	flag.StringVar(&healthCheck.Endpoint, "endpoint", "", "endpoint for http health checks")
+	flag.IntVar(&healthCheck.Timeout, "timeout", 0, "timeout in seconds for each health check")
+	flag.UintVar(&port, "port", 0, "port the app listens on")
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 0 {
+		packs.Exit(packs.FailCode(packs.CodeInvalidArgs, "parse arguments"))
+	}
+	packs.Exit(check())
+}
+
+func check() error {
+	if healthCheck.Type == "" {
+		configured, err := cf.ReadHealthCheck(appDir, metadataPath)
+		if err != nil {
+			return packs.FailErrCode(err, packs.CodeInvalidEnv, "read health check")
+		}
+		if healthCheck.Endpoint == "" {
+			healthCheck.Endpoint = configured.Endpoint
+		}
+		if healthCheck.Timeout == 0 {
+			healthCheck.Timeout = configured.Timeout
+		}
+		healthCheck.Type = configured.Type
+	}
+	healthCheck = healthCheck.WithDefaults()
+	if err := healthCheck.Validate(); err != nil {
+		return packs.FailErrCode(err, packs.CodeInvalidArgs, "validate health check")
+	}
+	if port == 0 {
+		port = appPort()
+	}
+	if err := healthCheck.Check(port); err != nil {
+		return packs.FailErr(err, "pass", healthCheck.Type, "health check")
+	}
+	return nil
+}
+
+// appPort returns the port configured by the exporter, or the port of the
+// app instance.
+func appPort() uint {
+	for _, k := range []string{packs.EnvAppPort, "PORT"} {
+		if p, err := strconv.ParseUint(os.Getenv(k), 10, 16); err == nil && p != 0 {
+			return uint(p)
+		}
+	}
+	return cf.DefaultPort
+}
@@ -3,12 +3,20 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/buildpack/packs"
 	"github.com/buildpack/packs/cf"
+	"github.com/buildpack/packs/droplet"
+	"github.com/buildpack/packs/launcher"
+	"github.com/buildpack/packs/procfile"
 )
 
 const (
@@ -20,41 +28,60 @@ const (
 var (
 	dropletPath  string
 	metadataPath string
+	servicesFile string
+	credentials  string
+	runningEnv   string
+	processType  string
+	formation    string
+	useInit      bool
+	gracePeriod  time.Duration
 	startCommand string
 )
 
 func init() {
-	packs.InputDropletPath(&dropletPath)
+	packs.InputDropletLocation(&dropletPath)
 	packs.InputMetadataPath(&metadataPath)
+	packs.InputServicesFile(&servicesFile)
+	packs.InputCredentials(&credentials)
+	packs.InputRunningEnv(&runningEnv)
+	packs.InputProcessType(&processType)
+	packs.InputProcesses(&formation)
+	if err := packs.InputInit(&useInit, &gracePeriod); err != nil {
+		packs.Exit(err)
+	}
 }
 
 func main() {
 	flag.Parse()
 	startCommand = strings.Join(flag.Args(), " ")
+	if servicesFile != "" {
+		os.Setenv(packs.EnvServicesFile, servicesFile)
+	}
+	if runningEnv != "" {
+		os.Setenv(packs.EnvRunningEnv, runningEnv)
+	}
 	packs.Exit(launch())
 }
 
 func launch() error {
 	if dropletPath != "" {
-		if _, err := packs.Run("tar", "-C", homeDir, "-xzf", dropletPath); err != nil {
-			return packs.FailErr(err, "supply app")
+		if err := supplyApp(); err != nil {
+			return err
 		}
 	}
 
-	var (
-		command string
-		err     error
-	)
-	switch {
-	case startCommand != "":
-		command = startCommand
-	case metadataPath != "":
-		command, err = readMetadataCommand(metadataPath)
-	default:
-		command, err = readDropletCommand(stagingInfoFile)
-	}
-	if err != nil {
-		return packs.FailErr(err, "determine start command")
+	command := startCommand
+	var processTypes map[string]string
+	if command == "" {
+		var err error
+		if processTypes, err = readProcessTypes(); err != nil {
+			return err
+		}
+		if formation == "" {
+			if command, err = findCommand(processTypes, processType); err != nil {
+				return err
+			}
+		}
 	}
 
 	if err := os.Chdir(appDir); err != nil {
@@ -64,41 +91,230 @@ func launch() error {
 	if err != nil {
 		return packs.FailErrCode(err, packs.CodeInvalidEnv, "build app env")
 	}
-	for k, v := range app.Launch() {
+	env, err := app.Launch()
+	if err != nil {
+		return packs.FailErrCode(err, packs.CodeInvalidEnv, "build app env")
+	}
+	var source cf.CredentialSource
+	if credentials != "" {
+		if source, err = cf.NewCredentialSource(credentials, os.Getenv(packs.EnvCredhubToken)); err != nil {
+			return packs.FailErrCode(err, packs.CodeInvalidEnv, "access credentials")
+		}
+		os.Unsetenv(packs.EnvCredhubToken)
+	}
+	if err := app.ProvideServices(env, source); err != nil {
+		return packs.FailErrCode(err, packs.CodeInvalidEnv, "provide services")
+	}
+	for k, v := range env {
 		if err := os.Setenv(k, v); err != nil {
 			return packs.FailErrCode(err, packs.CodeInvalidEnv, "set app env")
 		}
 	}
 
-	args := []string{"/lifecycle/launcher", appDir, command, ""}
-	if err := syscall.Exec("/lifecycle/launcher", args, os.Environ()); err != nil {
+	if formation != "" && startCommand == "" {
+		return supervise(processTypes)
+	}
+	if useInit {
+		return runInit(command)
+	}
+	if err := launcher.Launch(appDir, command, os.Environ()); err != nil {
 		return packs.FailErrCode(err, packs.CodeFailedLaunch, "launch")
 	}
 	return nil
 }
 
-func readDropletCommand(path string) (string, error) {
+// supervise runs each process type in the formation with its own port and
+// instance index.
+func supervise(processTypes map[string]string) error {
+	basePort, err := strconv.Atoi(os.Getenv("PORT"))
+	if err != nil {
+		basePort = cf.DefaultPort
+	}
+	procs, err := launcher.Processes(formation, processTypes, basePort)
+	if err != nil {
+		return packs.FailErrCode(err, packs.CodeInvalidArgs, "parse processes")
+	}
+	for i, p := range procs {
+		port := strconv.Itoa(p.Port)
+		index := strconv.Itoa(p.Index)
+		procs[i].Env = append(p.Env,
+			"VCAP_APP_PORT="+port,
+			"CF_INSTANCE_PORT="+port,
+			"CF_INSTANCE_ADDR="+net.JoinHostPort(os.Getenv("CF_INSTANCE_IP"), port),
+			fmt.Sprintf(`CF_INSTANCE_PORTS=[{"external":%d,"internal":%d}]`, p.Port, p.Port),
+			"INSTANCE_INDEX="+index,
+			"CF_INSTANCE_INDEX="+index,
+		)
+	}
+	supervisor := &launcher.Supervisor{
+		AppDir:      appDir,
+		Env:         os.Environ(),
+		Out:         os.Stdout,
+		Color:       packs.IsTerminal(os.Stdout),
+		GracePeriod: gracePeriod,
+	}
+	if err := supervisor.Run(procs); err != nil {
+		if exitErr, ok := err.(*launcher.ExitError); ok {
+			return packs.FailCode(exitErr.Code, "run", exitErr.Name)
+		}
+		return packs.FailErrCode(err, packs.CodeFailedLaunch, "run processes")
+	}
+	return nil
+}
+
+// runInit runs command as a child of the launcher, which reaps zombies and
+// forwards termination signals until the command exits.
+func runInit(command string) error {
+	cmd, err := launcher.Cmd(appDir, command, os.Environ())
+	if err != nil {
+		return packs.FailErrCode(err, packs.CodeFailedLaunch, "launch")
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	code, err := (&launcher.Init{Out: os.Stderr, GracePeriod: gracePeriod}).Run(cmd)
+	if err != nil {
+		return packs.FailErrCode(err, packs.CodeFailedLaunch, "launch")
+	}
+	if code != 0 {
+		return packs.FailCode(code, "run", processType)
+	}
+	return nil
+}
+
+// supplyApp extracts the droplet into the home directory. Local droplets
+// are verified against the digest in the metadata, if provided, before
+// they are extracted. Remote droplets are streamed, so they are verified
+// after they are extracted. Either way, the droplet is extracted into a
+// temporary directory that is only moved into place once it is verified.
+func supplyApp() error {
+	var metadata cf.PackMetadata
+	if metadataPath != "" {
+		dropletMetadata, err := readDropletMetadata(metadataPath)
+		if err != nil {
+			return err
+		}
+		metadata = dropletMetadata.PackMetadata
+	}
+	remote := droplet.IsRemote(dropletPath)
+	if !remote {
+		if err := metadata.VerifyDroplet(dropletPath); err != nil {
+			return packs.FailErrCode(err, packs.CodeFailedVerify, "verify", dropletPath)
+		}
+	}
+	rc, err := droplet.Open(dropletPath, os.Getenv(packs.EnvDropletAuth))
+	if err != nil {
+		return packs.FailErr(err, "open", dropletPath)
+	}
+	defer rc.Close()
+	os.Unsetenv(packs.EnvDropletAuth)
+
+	tmpDir, err := ioutil.TempDir(homeDir, ".droplet")
+	if err != nil {
+		return packs.FailErr(err, "create temp directory in", homeDir)
+	}
+	defer os.RemoveAll(tmpDir)
+	r := droplet.NewReader(rc)
+	if err := droplet.Extract(r, tmpDir); err != nil {
+		return packs.FailErrCode(err, packs.CodeFailedVerify, "extract", dropletPath)
+	}
+	if remote && metadata.DropletSHA256 != "" {
+		if err := r.Verify(dropletPath, metadata.DropletSHA256, metadata.DropletSize); err != nil {
+			return packs.FailErrCode(err, packs.CodeFailedVerify, "verify", dropletPath)
+		}
+	}
+	return moveAll(tmpDir, homeDir)
+}
+
+// moveAll moves each entry in src into dst, replacing any existing entry.
+func moveAll(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return packs.FailErr(err, "read", src)
+	}
+	for _, f := range files {
+		target := filepath.Join(dst, f.Name())
+		if err := os.RemoveAll(target); err != nil {
+			return packs.FailErr(err, "remove", target)
+		}
+		if err := os.Rename(filepath.Join(src, f.Name()), target); err != nil {
+			return packs.FailErr(err, "move droplet to", target)
+		}
+	}
+	return nil
+}
+
+// readProcessTypes returns the process types detected during staging,
+// overridden by the Procfile in the app.
+func readProcessTypes() (map[string]string, error) {
+	var (
+		processTypes map[string]string
+		err          error
+	)
+	if metadataPath != "" {
+		processTypes, err = readMetadataProcessTypes(metadataPath)
+	} else {
+		processTypes, err = readDropletProcessTypes(stagingInfoFile)
+	}
+	if err != nil {
+		return nil, packs.FailErr(err, "determine start command")
+	}
+	procfileTypes, err := procfile.Read(filepath.Join(appDir, "Procfile"))
+	if err != nil {
+		return nil, packs.FailErrCode(err, packs.CodeInvalidArgs, "parse Procfile")
+	}
+	for name, command := range procfileTypes {
+		processTypes[name] = command
+	}
+	return processTypes, nil
+}
+
+func findCommand(processTypes map[string]string, processType string) (string, error) {
+	command, err := procfile.Lookup(processTypes, processType)
+	if err != nil {
+		return "", packs.FailErrCode(err, packs.CodeInvalidArgs, "find start command")
+	}
+	return command, nil
+}
+
+func readDropletProcessTypes(path string) (map[string]string, error) {
 	f, err := os.Open(path)
 	if err != nil {
-		return "", packs.FailErr(err, "read droplet start command")
+		return nil, packs.FailErr(err, "read droplet start command")
 	}
+	defer f.Close()
 	var info struct {
 		StartCommand string `json:"start_command"`
 	}
 	if err := json.NewDecoder(f).Decode(&info); err != nil {
-		return "", packs.FailErr(err, "parse start command")
+		return nil, packs.FailErr(err, "parse start command")
+	}
+	processTypes := map[string]string{}
+	if info.StartCommand != "" {
+		processTypes["web"] = info.StartCommand
 	}
-	return info.StartCommand, nil
+	return processTypes, nil
 }
 
-func readMetadataCommand(path string) (string, error) {
-	f, err := os.Open(path)
+func readMetadataProcessTypes(path string) (map[string]string, error) {
+	metadata, err := readDropletMetadata(path)
 	if err != nil {
-		return "", packs.FailErr(err, "read metadata start command")
+		return nil, err
+	}
+	processTypes := map[string]string{}
+	for name, command := range metadata.ProcessTypes {
+		processTypes[name] = command
 	}
+	return processTypes, nil
+}
+
+func readDropletMetadata(path string) (cf.DropletMetadata, error) {
 	var metadata cf.DropletMetadata
+	f, err := os.Open(path)
+	if err != nil {
+		return metadata, packs.FailErr(err, "read droplet metadata")
+	}
+	defer f.Close()
 	if err := json.NewDecoder(f).Decode(&metadata); err != nil {
-		return "", packs.FailErr(err, "parse start command")
+		return metadata, packs.FailErr(err, "parse droplet metadata")
 	}
-	return metadata.ProcessTypes["web"], nil
+	return metadata, nil
 }
@@ -2,10 +2,11 @@ package main
 
 import (
 	"os"
-	"syscall"
+	"strings"
 
 	"github.com/buildpack/packs"
 	"github.com/buildpack/packs/cf"
+	"github.com/buildpack/packs/launcher"
 )
 
 const appDir = "/home/vcap/app"
@@ -22,13 +23,19 @@ func shell() error {
 	if err != nil {
 		return packs.FailErrCode(err, packs.CodeInvalidEnv, "build app env")
 	}
-	for k, v := range app.Launch() {
+	env, err := app.Launch()
+	if err != nil {
+		return packs.FailErrCode(err, packs.CodeInvalidEnv, "build app env")
+	}
+	if err := app.ProjectServiceBindings(env); err != nil {
+		return packs.FailErrCode(err, packs.CodeInvalidEnv, "project service bindings")
+	}
+	for k, v := range env {
 		if err := os.Setenv(k, v); err != nil {
 			return packs.FailErrCode(err, packs.CodeInvalidEnv, "set app env")
 		}
 	}
-	args := append([]string{"/lifecycle/shell", appDir}, os.Args[1:]...)
-	if err := syscall.Exec("/lifecycle/shell", args, os.Environ()); err != nil {
+	if err := launcher.Shell(appDir, strings.Join(os.Args[1:], " "), os.Environ()); err != nil {
 		return packs.FailErrCode(err, packs.CodeFailedLaunch, "run")
 	}
 	return nil
@@ -0,0 +1,162 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/buildpack/packs"
+)
+
+const credhubRef = "credhub-ref"
+
+// CredentialSource resolves the JSON credential stored under a CredHub name.
+type CredentialSource interface {
+	Credential(name string) (map[string]interface{}, error)
+}
+
+// NewCredentialSource returns a source for location, which may be the URL of
+// a CredHub-compatible server, a directory containing one file per
+// credential name, or a JSON or YAML file mapping names to credentials.
+func NewCredentialSource(location, token string) (CredentialSource, error) {
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		return &credentialServer{
+			url:    strings.TrimSuffix(location, "/"),
+			token:  token,
+			client: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	}
+	fi, err := os.Stat(location)
+	if err != nil {
+		return nil, packs.FailErr(err, "access credentials", location)
+	}
+	if fi.IsDir() {
+		return credentialDir(location), nil
+	}
+	contents, err := ioutil.ReadFile(location)
+	if err != nil {
+		return nil, packs.FailErr(err, "read credentials file", location)
+	}
+	var creds map[string]interface{}
+	if err := yaml.Unmarshal(contents, &creds); err != nil {
+		return nil, packs.FailErr(err, "parse credentials file", location)
+	}
+	return credentialFile(creds), nil
+}
+
+// InterpolateCredentials replaces the credentials of each service in
+// vcapServices that consist only of a credhub-ref with the credential it
+// refers to. The result contains secrets and must only be kept in memory.
+func InterpolateCredentials(vcapServices string, source CredentialSource) (string, error) {
+	var services map[string][]map[string]interface{}
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+		return "", packs.FailErr(err, "parse VCAP_SERVICES")
+	}
+	for _, list := range services {
+		for _, s := range list {
+			creds, ok := s["credentials"].(map[string]interface{})
+			if !ok || len(creds) != 1 {
+				continue
+			}
+			ref, ok := creds[credhubRef].(string)
+			if !ok {
+				continue
+			}
+			cred, err := source.Credential(ref)
+			if err != nil {
+				return "", packs.FailErr(err, "resolve", credhubRef, ref, "for service", fmt.Sprint(s["name"]))
+			}
+			s["credentials"] = cred
+		}
+	}
+	out, err := json.Marshal(services)
+	if err != nil {
+		return "", packs.FailErr(err, "encode VCAP_SERVICES")
+	}
+	return string(out), nil
+}
+
+type credentialFile map[string]interface{}
+
+func (f credentialFile) Credential(name string) (map[string]interface{}, error) {
+	v, ok := f[name]
+	if !ok {
+		return nil, fmt.Errorf("credential not found")
+	}
+	return credentialMap(jsonValue(v))
+}
+
+type credentialDir string
+
+func (d credentialDir) Credential(name string) (map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(string(d), filepath.Clean("/"+name)))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("credential not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(contents, &v); err != nil {
+		return nil, err
+	}
+	return credentialMap(v)
+}
+
+type credentialServer struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (s *credentialServer) Credential(name string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", s.url+"/api/v1/data?"+url.Values{
+		"name":    {name},
+		"current": {"true"},
+	}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("credential not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var body struct {
+		Data []struct {
+			Value interface{} `json:"value"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if len(body.Data) == 0 {
+		return nil, fmt.Errorf("credential not found")
+	}
+	return credentialMap(body.Data[0].Value)
+}
+
+func credentialMap(v interface{}) (map[string]interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("credential must be a map")
+	}
+	return m, nil
+}
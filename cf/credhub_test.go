@@ -0,0 +1,116 @@
+package cf_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/cf"
+)
+
+func testInterpolateCredentials(t *testing.T, when spec.G, it spec.S) {
+	const (
+		vcapServices = `{
+			"p-mysql": [{"name": "some-db", "credentials": {"credhub-ref": "/c/p-mysql/some-db/credentials"}}],
+			"user-provided": [{"name": "some-ups", "credentials": {"key": "value"}}]
+		}`
+		expected = `{
+			"p-mysql": [{"name": "some-db", "credentials": {"user": "some-user", "port": 3306}}],
+			"user-provided": [{"name": "some-ups", "credentials": {"key": "value"}}]
+		}`
+	)
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.credhub.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	interpolate := func(location, token string) (string, error) {
+		t.Helper()
+		source, err := cf.NewCredentialSource(location, token)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		return cf.InterpolateCredentials(vcapServices, source)
+	}
+
+	when("the source is a file", func() {
+		it("should replace credhub-ref credentials", func() {
+			path := filepath.Join(tmpDir, "credentials.yml")
+			writeFile(t, path, "/c/p-mysql/some-db/credentials:\n  user: some-user\n  port: 3306\n")
+
+			out, err := interpolate(path, "")
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			jsonCmp(t, out, expected)
+		})
+
+		it("should return an error if the credential is missing", func() {
+			path := filepath.Join(tmpDir, "credentials.json")
+			writeFile(t, path, `{"/c/other": {"user": "some-user"}}`)
+
+			if _, err := interpolate(path, ""); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+
+	when("the source is a directory", func() {
+		it("should read each credential from a file named by the reference", func() {
+			writeFile(t, filepath.Join(tmpDir, "c", "p-mysql", "some-db", "credentials"), `{"user": "some-user", "port": 3306}`)
+
+			out, err := interpolate(tmpDir, "")
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			jsonCmp(t, out, expected)
+		})
+
+		it("should return an error if the credential is not a map", func() {
+			writeFile(t, filepath.Join(tmpDir, "c", "p-mysql", "some-db", "credentials"), `"some-password"`)
+
+			if _, err := interpolate(tmpDir, ""); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+
+	when("the source is a CredHub server", func() {
+		it("should fetch the current value of each credential", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v1/data" ||
+					r.URL.Query().Get("name") != "/c/p-mysql/some-db/credentials" ||
+					r.URL.Query().Get("current") != "true" ||
+					r.Header.Get("Authorization") != "Bearer some-token" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(`{"data": [{"type": "json", "value": {"user": "some-user", "port": 3306}}]}`))
+			}))
+			defer server.Close()
+
+			out, err := interpolate(server.URL, "some-token")
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			jsonCmp(t, out, expected)
+
+			if _, err := interpolate(server.URL, "other-token"); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+}
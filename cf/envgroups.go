@@ -0,0 +1,81 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/buildpack/packs"
+)
+
+// StagingEnv returns the user-provided env vars for staging: the staging
+// env var group, overridden by the env section of the app manifest.
+func (a *App) StagingEnv() (map[string]string, error) {
+	return a.userEnv(packs.EnvStagingEnv)
+}
+
+// RunningEnv returns the user-provided env vars for running: the running
+// env var group, overridden by the env section of the app manifest.
+func (a *App) RunningEnv() (map[string]string, error) {
+	return a.userEnv(packs.EnvRunningEnv)
+}
+
+func (a *App) userEnv(groupKey string) (map[string]string, error) {
+	group := map[string]string{}
+	if path := a.envStr(groupKey, ""); path != "" {
+		var err error
+		if group, err = readEnvGroup(path); err != nil {
+			return nil, err
+		}
+	}
+	manifest, err := a.manifestEnv()
+	if err != nil {
+		return nil, err
+	}
+	return mergeMaps(group, manifest), nil
+}
+
+func readEnvGroup(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, packs.FailErr(err, "read env var group", path)
+	}
+	var group map[string]interface{}
+	if err := yaml.Unmarshal(contents, &group); err != nil {
+		return nil, packs.FailErr(err, "parse env var group", path)
+	}
+	return envValues(group)
+}
+
+func (a *App) manifestEnv() (map[string]string, error) {
+	manifest, err := a.manifest()
+	if err != nil {
+		return nil, err
+	}
+	return envValues(manifest.Env)
+}
+
+// envValues converts YAML values into env var values, encoding any maps
+// or lists as JSON.
+func envValues(m map[string]interface{}) (map[string]string, error) {
+	out := map[string]string{}
+	for k, v := range m {
+		switch v := jsonValue(v).(type) {
+		case nil:
+			out[k] = ""
+		case string:
+			out[k] = v
+		case map[string]interface{}, []interface{}:
+			j, err := json.Marshal(v)
+			if err != nil {
+				return nil, packs.FailErr(err, "encode value of", k)
+			}
+			out[k] = string(j)
+		default:
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package cf_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cf"
+)
+
+func testEnvGroups(t *testing.T, when spec.G, it spec.S) {
+	var (
+		app    *cf.App
+		set    func(k, v string)
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if app, err = cf.New(); err != nil {
+			t.Fatalf("Failed to create app: %s\n", err)
+		}
+		app.Env, set = env()
+		if tmpDir, err = ioutil.TempDir("", "pack.envgroups.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		app.Dir = filepath.Join(tmpDir, "app")
+
+		writeFile(t, filepath.Join(tmpDir, "staging.json"), `{"REGISTRY_TOKEN": "some-token", "SOME_VAR": "staging", "MEMORY_LIMIT": "10m"}`)
+		writeFile(t, filepath.Join(tmpDir, "running.yml"), "SOME_VAR: running\nOTHER_VAR: running\nSOME_NUM: 5\nSOME_MAP: {a: b}\n")
+		set(packs.EnvStagingEnv, filepath.Join(tmpDir, "staging.json"))
+		set(packs.EnvRunningEnv, filepath.Join(tmpDir, "running.yml"))
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should apply the staging group only to the staging env", func() {
+		stage := stage(t, app)
+		launch := launch(t, app)
+
+		compare(t, stage, cmpMap{
+			{"REGISTRY_TOKEN", "some-token", nil},
+			{"SOME_VAR", "staging", nil},
+			{"MEMORY_LIMIT", "10m", nil},
+		})
+		compare(t, launch, cmpMap{
+			{"SOME_VAR", "running", nil},
+			{"OTHER_VAR", "running", nil},
+			{"SOME_NUM", "5", nil},
+			{"SOME_MAP", `{"a":"b"}`, nil},
+		})
+		if _, ok := launch["REGISTRY_TOKEN"]; ok {
+			t.Fatal("Staging env var leaked into launch env")
+		}
+		if _, ok := stage["OTHER_VAR"]; ok {
+			t.Fatal("Running env var leaked into staging env")
+		}
+	})
+
+	when("the app has a manifest", func() {
+		it.Before(func() {
+			writeFile(t, filepath.Join(app.Dir, "manifest.yml"), `
+env:
+  INHERITED_VAR: inherited
+applications:
+- name: other-app
+  env:
+    SOME_VAR: other-app
+- name: some-app
+  env:
+    SOME_VAR: manifest
+`)
+			set(packs.EnvAppName, "some-app")
+		})
+
+		it("should override the groups with the env of the matching app", func() {
+			compare(t, launch(t, app), cmpMap{
+				{"SOME_VAR", "manifest", nil},
+				{"OTHER_VAR", "running", nil},
+				{"INHERITED_VAR", "inherited", nil},
+			})
+			compare(t, stage(t, app), cmpMap{
+				{"SOME_VAR", "manifest", nil},
+				{"REGISTRY_TOKEN", "some-token", nil},
+			})
+		})
+
+		it("should let explicitly set env vars override the manifest", func() {
+			set("SOME_VAR", "explicit")
+			compare(t, launch(t, app), cmpMap{
+				{"SOME_VAR", "explicit", nil},
+			})
+		})
+	})
+
+	when("a group file is invalid", func() {
+		it("should return an error", func() {
+			writeFile(t, filepath.Join(tmpDir, "running.yml"), "- some-list")
+			if _, err := app.RunningEnv(); err == nil {
+				t.Fatal("Expected error")
+			}
+			if _, err := app.Launch(); err == nil {
+				t.Fatal("Expected error")
+			}
+			if _, err := app.StagingEnv(); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+		})
+	})
+}
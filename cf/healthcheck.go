@@ -0,0 +1,151 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HealthCheckPort    = "port"
+	HealthCheckHTTP    = "http"
+	HealthCheckProcess = "process"
+
+	// deprecated name for process
+	healthCheckNone = "none"
+
+	// DefaultHealthCheckTimeout matches the invocation timeout used by Diego.
+	DefaultHealthCheckTimeout = time.Second
+)
+
+// HealthCheck describes how to check that an app instance is healthy, using
+// the same health check types as Diego.
+type HealthCheck struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+}
+
+// HealthCheck returns the health check configured in the app manifest, or
+// a port health check by default.
+func (a *App) HealthCheck() (HealthCheck, error) {
+	manifest, err := a.manifest()
+	if err != nil {
+		return HealthCheck{}, err
+	}
+	hc := HealthCheck{
+		Type:     manifest.HealthCheckType,
+		Endpoint: manifest.HealthCheckHTTPEndpoint,
+		Timeout:  manifest.HealthCheckInvocationTimeout,
+	}.WithDefaults()
+	if err := hc.Validate(); err != nil {
+		return HealthCheck{}, err
+	}
+	return hc, nil
+}
+
+// ReadHealthCheck returns the health check configured in the manifest in
+// dir, or the health check recorded in the droplet metadata at metadataPath
+// if the manifest does not set a type and metadataPath is not empty. Unlike
+// App.HealthCheck, it does not read the container resources that New
+// detects.
+func ReadHealthCheck(dir, metadataPath string) (HealthCheck, error) {
+	app := &App{Env: os.LookupEnv, Dir: dir, name: defaultAppName}
+	manifest, err := app.manifest()
+	if err != nil {
+		return HealthCheck{}, err
+	}
+	if manifest.HealthCheckType != "" || metadataPath == "" {
+		return app.HealthCheck()
+	}
+	f, err := os.Open(metadataPath)
+	if err != nil {
+		return HealthCheck{}, err
+	}
+	defer f.Close()
+	var metadata DropletMetadata
+	if err := json.NewDecoder(f).Decode(&metadata); err != nil {
+		return HealthCheck{}, fmt.Errorf("invalid droplet metadata %s: %s", metadataPath, err)
+	}
+	if metadata.PackMetadata.HealthCheck == nil {
+		return app.HealthCheck()
+	}
+	hc := metadata.PackMetadata.HealthCheck.WithDefaults()
+	if err := hc.Validate(); err != nil {
+		return HealthCheck{}, err
+	}
+	return hc, nil
+}
+
+// WithDefaults returns the health check with a port type if no type is set,
+// and with the deprecated none type replaced by process.
+func (h HealthCheck) WithDefaults() HealthCheck {
+	switch h.Type {
+	case "":
+		h.Type = HealthCheckPort
+	case healthCheckNone:
+		h.Type = HealthCheckProcess
+	}
+	return h
+}
+
+// Validate returns an error if the health check type is unknown or the
+// endpoint or timeout is invalid.
+func (h HealthCheck) Validate() error {
+	switch h.Type {
+	case HealthCheckPort, HealthCheckProcess:
+	case HealthCheckHTTP:
+		if h.Endpoint != "" && !strings.HasPrefix(h.Endpoint, "/") {
+			return fmt.Errorf("invalid health check endpoint %s: must start with /", h.Endpoint)
+		}
+	default:
+		return fmt.Errorf("invalid health check type %s: must be %s, %s or %s", h.Type, HealthCheckPort, HealthCheckHTTP, HealthCheckProcess)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("invalid health check timeout %d", h.Timeout)
+	}
+	return nil
+}
+
+// Check checks the app listening on port. A port health check succeeds if
+// a TCP connection is accepted, and an http health check succeeds if the
+// endpoint returns 200 OK. A process health check always succeeds, as the
+// app is healthy as long as its process is running.
+func (h HealthCheck) Check(port uint) error {
+	timeout := DefaultHealthCheckTimeout
+	if h.Timeout > 0 {
+		timeout = time.Duration(h.Timeout) * time.Second
+	}
+	addr := net.JoinHostPort("localhost", strconv.FormatUint(uint64(port), 10))
+	switch h.Type {
+	case HealthCheckPort:
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case HealthCheckHTTP:
+		endpoint := h.Endpoint
+		if endpoint == "" {
+			endpoint = "/"
+		}
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Get("http://" + addr + endpoint)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+		}
+		return nil
+	case HealthCheckProcess:
+		return nil
+	}
+	return h.Validate()
+}
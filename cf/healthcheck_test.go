@@ -0,0 +1,217 @@
+package cf_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cf"
+)
+
+func testHealthCheck(t *testing.T, when spec.G, it spec.S) {
+	var (
+		app    *cf.App
+		set    func(k, v string)
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if app, err = cf.New(); err != nil {
+			t.Fatalf("Failed to create app: %s\n", err)
+		}
+		app.Env, set = env()
+		if tmpDir, err = ioutil.TempDir("", "pack.healthcheck.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		app.Dir = tmpDir
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("#HealthCheck", func() {
+		it("should default to a port health check", func() {
+			if hc, err := app.HealthCheck(); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if !reflect.DeepEqual(hc, cf.HealthCheck{Type: cf.HealthCheckPort}) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+		})
+
+		it("should read the health check for the app from the manifest", func() {
+			writeFile(t, filepath.Join(tmpDir, "manifest.yml"), `
+health-check-invocation-timeout: 5
+applications:
+- name: other-app
+  health-check-type: process
+- name: some-app
+  health-check-type: http
+  health-check-http-endpoint: /health
+`)
+			set(packs.EnvAppName, "some-app")
+			expected := cf.HealthCheck{Type: cf.HealthCheckHTTP, Endpoint: "/health", Timeout: 5}
+			if hc, err := app.HealthCheck(); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if !reflect.DeepEqual(hc, expected) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+		})
+
+		it("should treat none as a process health check", func() {
+			writeFile(t, filepath.Join(tmpDir, "manifest.yml"), "health-check-type: none\n")
+			if hc, err := app.HealthCheck(); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if hc.Type != cf.HealthCheckProcess {
+				t.Fatalf("Incorrect health check type: %s\n", hc.Type)
+			}
+		})
+
+		it("should return an error for an invalid health check", func() {
+			writeFile(t, filepath.Join(tmpDir, "manifest.yml"), "health-check-type: some-type\n")
+			if _, err := app.HealthCheck(); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+
+	when("#ReadHealthCheck", func() {
+		var metadataPath string
+
+		it.Before(func() {
+			metadataPath = filepath.Join(tmpDir, "result.json")
+			writeFile(t, metadataPath, `{"pack_metadata": {"health_check": {"type": "http", "endpoint": "/health", "timeout": 5}}}`)
+		})
+
+		it("should read the manifest without an app", func() {
+			writeFile(t, filepath.Join(tmpDir, "manifest.yml"), "health-check-type: process\n")
+			if hc, err := cf.ReadHealthCheck(tmpDir, metadataPath); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if !reflect.DeepEqual(hc, cf.HealthCheck{Type: cf.HealthCheckProcess}) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+		})
+
+		it("should fall back to the droplet metadata", func() {
+			writeFile(t, filepath.Join(tmpDir, "manifest.yml"), "health-check-invocation-timeout: 10\n")
+			expected := cf.HealthCheck{Type: cf.HealthCheckHTTP, Endpoint: "/health", Timeout: 5}
+			if hc, err := cf.ReadHealthCheck(tmpDir, metadataPath); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if !reflect.DeepEqual(hc, expected) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+		})
+
+		it("should treat none in the droplet metadata as a process health check", func() {
+			writeFile(t, metadataPath, `{"pack_metadata": {"health_check": {"type": "none"}}}`)
+			if hc, err := cf.ReadHealthCheck(tmpDir, metadataPath); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if hc.Type != cf.HealthCheckProcess {
+				t.Fatalf("Incorrect health check type: %s\n", hc.Type)
+			}
+		})
+
+		it("should default to a port health check", func() {
+			writeFile(t, metadataPath, `{"pack_metadata": {}}`)
+			if hc, err := cf.ReadHealthCheck(tmpDir, metadataPath); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if !reflect.DeepEqual(hc, cf.HealthCheck{Type: cf.HealthCheckPort}) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+			if hc, err := cf.ReadHealthCheck(tmpDir, ""); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if !reflect.DeepEqual(hc, cf.HealthCheck{Type: cf.HealthCheckPort}) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+		})
+
+		it("should return an error for unreadable droplet metadata", func() {
+			writeFile(t, metadataPath, "{")
+			if _, err := cf.ReadHealthCheck(tmpDir, metadataPath); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+
+	when("#WithDefaults", func() {
+		it("should treat none as a process health check", func() {
+			if hc := (cf.HealthCheck{Type: "none", Timeout: 5}).WithDefaults(); !reflect.DeepEqual(hc, cf.HealthCheck{Type: cf.HealthCheckProcess, Timeout: 5}) {
+				t.Fatalf("Incorrect health check: %#v\n", hc)
+			}
+			if err := (cf.HealthCheck{Type: "none"}).WithDefaults().Validate(); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+		})
+
+		it("should default to a port health check", func() {
+			if hc := (cf.HealthCheck{}).WithDefaults(); hc.Type != cf.HealthCheckPort {
+				t.Fatalf("Incorrect health check type: %s\n", hc.Type)
+			}
+		})
+	})
+
+	when("#Check", func() {
+		var (
+			server *httptest.Server
+			port   uint
+		)
+
+		it.Before(func() {
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/health" {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			_, p, err := net.SplitHostPort(server.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			p64, err := strconv.ParseUint(p, 10, 16)
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			port = uint(p64)
+		})
+
+		it.After(func() {
+			server.Close()
+		})
+
+		it("should check that the port accepts connections", func() {
+			hc := cf.HealthCheck{Type: cf.HealthCheckPort}
+			if err := hc.Check(port); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			server.Close()
+			if err := hc.Check(port); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+
+		it("should check that the endpoint returns 200 OK", func() {
+			if err := (cf.HealthCheck{Type: cf.HealthCheckHTTP, Endpoint: "/health"}).Check(port); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if err := (cf.HealthCheck{Type: cf.HealthCheckHTTP}).Check(port); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+
+		it("should always pass a process health check", func() {
+			server.Close()
+			if err := (cf.HealthCheck{Type: cf.HealthCheckProcess}).Check(port); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+		})
+	})
+}
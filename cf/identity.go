@@ -0,0 +1,71 @@
+package cf
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/buildpack/packs"
+)
+
+type Identity struct {
+	AppName       string `yaml:"name"`
+	AppGUID       string `yaml:"app_guid"`
+	SpaceName     string `yaml:"space_name"`
+	SpaceGUID     string `yaml:"space_guid"`
+	OrgName       string `yaml:"org_name"`
+	OrgGUID       string `yaml:"org_guid"`
+	Version       string `yaml:"version"`
+	InstanceIndex uint   `yaml:"instance_index"`
+}
+
+// Identity returns the identity of the app, read from env vars and the app
+// config file. GUIDs that are not provided are derived from the names, so
+// that they are stable across staging and restarts.
+func (a *App) Identity() (Identity, error) {
+	var id Identity
+	if path := a.envStr(packs.EnvAppConfig, ""); path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Identity{}, packs.FailErr(err, "read app config", path)
+		}
+		if err := yaml.Unmarshal(contents, &id); err != nil {
+			return Identity{}, packs.FailErr(err, "parse app config", path)
+		}
+	}
+	return a.identityDefaults(id), nil
+}
+
+func (a *App) identityDefaults(id Identity) Identity {
+	id.AppName = a.envStr(packs.EnvAppName, defaultStr(id.AppName, a.name))
+	id.OrgName = a.envStr(packs.EnvOrgName, defaultStr(id.OrgName, id.AppName+"-org"))
+	id.SpaceName = a.envStr(packs.EnvSpaceName, defaultStr(id.SpaceName, id.AppName+"-space"))
+	id.OrgGUID = a.envStr(packs.EnvOrgGUID, defaultStr(id.OrgGUID, nameUUID("org", id.OrgName)))
+	id.SpaceGUID = a.envStr(packs.EnvSpaceGUID, defaultStr(id.SpaceGUID, nameUUID("space", id.OrgGUID, id.SpaceName)))
+	id.AppGUID = a.envStr(packs.EnvAppGUID, defaultStr(id.AppGUID, nameUUID("app", id.SpaceGUID, id.AppName)))
+	id.Version = a.envStr(packs.EnvAppVersion, defaultStr(id.Version, nameUUID("version", id.AppGUID)))
+	id.InstanceIndex = uint(a.envInt(packs.EnvInstanceIndex, uint64(id.InstanceIndex)))
+	return id
+}
+
+// nameUUID returns a version 5 style UUID derived from the SHA-1 hash of
+// the provided names.
+func nameUUID(names ...string) string {
+	h := sha1.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%d:%s", len(name), name)
+	}
+	b := h.Sum(nil)
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func defaultStr(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
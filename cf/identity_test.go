@@ -0,0 +1,154 @@
+package cf_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cf"
+)
+
+func testIdentity(t *testing.T, when spec.G, it spec.S) {
+	var (
+		app    *cf.App
+		set    func(k, v string)
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if app, err = cf.New(); err != nil {
+			t.Fatalf("Failed to create app: %s\n", err)
+		}
+		app.Env, set = env()
+		if tmpDir, err = ioutil.TempDir("", "pack.identity.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should derive stable GUIDs from the app name", func() {
+		set(packs.EnvAppName, "some-name")
+		otherApp, err := cf.New()
+		if err != nil {
+			t.Fatalf("Failed to create app: %s\n", err)
+		}
+		otherApp.Env = app.Env
+
+		id1, err := app.Identity()
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		id2, err := otherApp.Identity()
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if !reflect.DeepEqual(id1, id2) {
+			t.Fatalf("Mismatched identity:\n%#v\n!=\n%#v\n", id1, id2)
+		}
+		for _, guid := range []string{id1.AppGUID, id1.SpaceGUID, id1.OrgGUID, id1.Version} {
+			uuidCmp(t, guid, "")
+		}
+		if id1.AppGUID == id1.SpaceGUID || id1.SpaceGUID == id1.OrgGUID {
+			t.Fatalf("Expected distinct GUIDs: %#v\n", id1)
+		}
+
+		set(packs.EnvAppName, "some-other-name")
+		id3, err := app.Identity()
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if id3.AppGUID == id1.AppGUID {
+			t.Fatalf("Expected different app GUID for different name: %s\n", id3.AppGUID)
+		}
+	})
+
+	it("should use the same identity for staging and launch", func() {
+		var staged, launched cf.VCAPApplication
+		if err := json.Unmarshal([]byte(stage(t, app)["VCAP_APPLICATION"]), &staged); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := json.Unmarshal([]byte(launch(t, app)["VCAP_APPLICATION"]), &launched); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if staged.ApplicationID != launched.ApplicationID ||
+			staged.SpaceID != launched.SpaceID ||
+			staged.OrganizationID != launched.OrganizationID ||
+			staged.Version != launched.Version {
+			t.Fatalf("Mismatched identity:\n%#v\n!=\n%#v\n", staged, launched)
+		}
+	})
+
+	when("a config file is provided", func() {
+		it("should read the identity from the file and allow env overrides", func() {
+			path := filepath.Join(tmpDir, "app.yml")
+			writeFile(t, path, `
+name: some-name
+app_guid: some-app-guid
+space_name: some-space
+org_name: some-org
+org_guid: some-org-guid
+version: some-version
+instance_index: 2
+`)
+			set(packs.EnvAppConfig, path)
+			set(packs.EnvSpaceGUID, "some-space-guid")
+			set(packs.EnvInstanceIndex, "3")
+
+			id, err := app.Identity()
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if expected := (cf.Identity{
+				AppName:       "some-name",
+				AppGUID:       "some-app-guid",
+				SpaceName:     "some-space",
+				SpaceGUID:     "some-space-guid",
+				OrgName:       "some-org",
+				OrgGUID:       "some-org-guid",
+				Version:       "some-version",
+				InstanceIndex: 3,
+			}); !reflect.DeepEqual(id, expected) {
+				t.Fatalf("Mismatched identity:\n%#v\n!=\n%#v\n", id, expected)
+			}
+
+			env := launch(t, app)
+			compare(t, env, cmpMap{
+				{"CF_INSTANCE_INDEX", "3", nil},
+				{"INSTANCE_INDEX", "3", nil},
+			})
+			var vcapApp cf.VCAPApplication
+			if err := json.Unmarshal([]byte(env["VCAP_APPLICATION"]), &vcapApp); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if vcapApp.ApplicationID != "some-app-guid" ||
+				vcapApp.SpaceName != "some-space" ||
+				vcapApp.OrganizationName != "some-org" ||
+				*vcapApp.InstanceIndex != 3 {
+				t.Fatalf("Incorrect VCAP_APPLICATION: %#v\n", vcapApp)
+			}
+		})
+
+		it("should return an error if the file is invalid", func() {
+			path := filepath.Join(tmpDir, "app.yml")
+			writeFile(t, path, "instance_index: some-index")
+			set(packs.EnvAppConfig, path)
+
+			if _, err := app.Identity(); err == nil {
+				t.Fatal("Expected error")
+			}
+			if _, err := app.Stage(); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package cf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/buildpack/packs"
+)
+
+const manifestFile = "manifest.yml"
+
+type manifestApp struct {
+	Name                         string                 `yaml:"name"`
+	Env                          map[string]interface{} `yaml:"env"`
+	HealthCheckType              string                 `yaml:"health-check-type"`
+	HealthCheckHTTPEndpoint      string                 `yaml:"health-check-http-endpoint"`
+	HealthCheckInvocationTimeout int                    `yaml:"health-check-invocation-timeout"`
+}
+
+// manifest returns the entry for the app in the manifest in the app dir,
+// or the first entry if none matches the app name, merged with the
+// top-level attributes of the manifest.
+func (a *App) manifest() (manifestApp, error) {
+	path := filepath.Join(a.Dir, manifestFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifestApp{}, nil
+	}
+	if err != nil {
+		return manifestApp{}, packs.FailErr(err, "read manifest", path)
+	}
+	var manifest struct {
+		manifestApp  `yaml:",inline"`
+		Applications []manifestApp `yaml:"applications"`
+	}
+	if err := yaml.Unmarshal(contents, &manifest); err != nil {
+		return manifestApp{}, packs.FailErr(err, "parse manifest", path)
+	}
+	out := manifest.manifestApp
+	if apps := manifest.Applications; len(apps) > 0 {
+		id, err := a.Identity()
+		if err != nil {
+			return manifestApp{}, err
+		}
+		app := apps[0]
+		for _, other := range apps {
+			if other.Name == id.AppName {
+				app = other
+				break
+			}
+		}
+		out.Name = app.Name
+		out.Env = mergeInterfaceMaps(out.Env, app.Env)
+		if app.HealthCheckType != "" {
+			out.HealthCheckType = app.HealthCheckType
+		}
+		if app.HealthCheckHTTPEndpoint != "" {
+			out.HealthCheckHTTPEndpoint = app.HealthCheckHTTPEndpoint
+		}
+		if app.HealthCheckInvocationTimeout != 0 {
+			out.HealthCheckInvocationTimeout = app.HealthCheckInvocationTimeout
+		}
+	}
+	return out, nil
+}
+
+func mergeInterfaceMaps(maps ...map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, m := range maps {
+		for k, v := range m {
+			result[k] = v
+		}
+	}
+	return result
+}
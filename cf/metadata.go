@@ -3,6 +3,7 @@ package cf
 import (
 	"code.cloudfoundry.org/buildpackapplifecycle"
 	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/droplet"
 )
 
 type DropletMetadata struct {
@@ -23,5 +24,19 @@ func (d *DropletMetadata) Buildpacks() []packs.BuildpackMetadata {
 }
 
 type PackMetadata struct {
-	App packs.AppMetadata `json:"app"`
+	App           packs.AppMetadata `json:"app"`
+	Stack         string            `json:"stack,omitempty"`
+	HealthCheck   *HealthCheck      `json:"health_check,omitempty"`
+	DropletSHA256 string            `json:"droplet_sha256,omitempty"`
+	DropletSize   int64             `json:"droplet_size,omitempty"`
+}
+
+// VerifyDroplet returns a *droplet.VerifyError if the droplet at path does
+// not match the recorded digest. Droplets staged without a digest are not
+// verified.
+func (p *PackMetadata) VerifyDroplet(path string) error {
+	if p.DropletSHA256 == "" {
+		return nil
+	}
+	return droplet.Verify(path, p.DropletSHA256, p.DropletSize)
 }
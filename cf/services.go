@@ -0,0 +1,232 @@
+package cf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/buildpack/packs"
+)
+
+const (
+	bindingsDir         = "/platform/bindings"
+	userProvidedLabel   = "user-provided"
+	bindingCredentials  = "credentials.json"
+	bindingLabel        = "label"
+	bindingPlan         = "plan"
+	bindingTags         = "tags"
+	bindingInstanceName = "instance_name"
+)
+
+type VCAPServices map[string][]VCAPService
+
+type VCAPService struct {
+	BindingName    *string                `json:"binding_name"`
+	Credentials    map[string]interface{} `json:"credentials"`
+	InstanceName   string                 `json:"instance_name"`
+	Label          string                 `json:"label"`
+	Name           string                 `json:"name"`
+	Plan           string                 `json:"plan"`
+	Provider       *string                `json:"provider"`
+	SyslogDrainURL *string                `json:"syslog_drain_url"`
+	Tags           []string               `json:"tags"`
+	VolumeMounts   []interface{}          `json:"volume_mounts"`
+}
+
+// Services returns the services bound to the app, read from the services
+// file if one is set, then from SERVICE_BINDING_ROOT, then from the
+// services directory if one is set. Otherwise, they are read from the
+// default SERVICE_BINDING_ROOT if it exists and was not projected by packs,
+// and from the bindings directory if it does not.
+func (a *App) Services() (VCAPServices, error) {
+	var (
+		services []VCAPService
+		err      error
+	)
+	if path := a.envStr(packs.EnvServicesFile, ""); path != "" {
+		services, err = readServicesFile(path)
+	} else if root := a.envStr(serviceBindingRoot, ""); root != "" {
+		services, err = readBindingsDir(root)
+	} else if dir := a.envStr(packs.EnvServicesDir, ""); dir != "" {
+		services, err = readBindingsDir(dir)
+	} else if _, statErr := os.Stat(defaultServiceBindingRoot); statErr == nil && !isProjection(defaultServiceBindingRoot) {
+		services, err = readBindingsDir(defaultServiceBindingRoot)
+	} else {
+		services, err = readBindingsDir(bindingsDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := VCAPServices{}
+	for _, s := range services {
+		if s.Label == "" {
+			s.Label = userProvidedLabel
+		}
+		if s.InstanceName == "" {
+			s.InstanceName = s.Name
+		}
+		if s.Credentials == nil {
+			s.Credentials = map[string]interface{}{}
+		}
+		if s.Tags == nil {
+			s.Tags = []string{}
+		}
+		if s.VolumeMounts == nil {
+			s.VolumeMounts = []interface{}{}
+		}
+		out[s.Label] = append(out[s.Label], s)
+	}
+	return out, nil
+}
+
+func (a *App) vcapServices() (string, error) {
+	services, err := a.Services()
+	if err != nil {
+		return "", packs.FailErr(err, "read service bindings")
+	}
+	out, err := json.Marshal(services)
+	if err != nil {
+		return "", packs.FailErr(err, "encode service bindings")
+	}
+	return string(out), nil
+}
+
+func readBindingsDir(dir string) ([]VCAPService, error) {
+	if isProjection(dir) {
+		return readProjection(dir)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, packs.FailErr(err, "read bindings directory", dir)
+	}
+	var services []VCAPService
+	for _, f := range files {
+		if !f.IsDir() {
+			continue
+		}
+		service, err := readBinding(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func readBinding(dir string) (VCAPService, error) {
+	if _, err := os.Stat(filepath.Join(dir, bindingType)); err == nil {
+		return readProjectedBinding(dir)
+	}
+	service := VCAPService{Name: filepath.Base(dir)}
+	for _, field := range []struct {
+		file string
+		val  *string
+	}{
+		{bindingLabel, &service.Label},
+		{bindingPlan, &service.Plan},
+		{bindingInstanceName, &service.InstanceName},
+	} {
+		v, err := readBindingFile(dir, field.file)
+		if err != nil {
+			return VCAPService{}, err
+		}
+		*field.val = strings.TrimSpace(v)
+	}
+
+	tags, err := readBindingFile(dir, bindingTags)
+	if err != nil {
+		return VCAPService{}, err
+	}
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == '\n' || r == ',' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			service.Tags = append(service.Tags, tag)
+		}
+	}
+
+	creds, err := readBindingFile(dir, bindingCredentials)
+	if err != nil {
+		return VCAPService{}, err
+	}
+	if creds != "" {
+		if err := json.Unmarshal([]byte(creds), &service.Credentials); err != nil {
+			return VCAPService{}, packs.FailErr(err, "parse credentials for", service.Name)
+		}
+	}
+	return service, nil
+}
+
+func readBindingFile(dir, name string) (string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", packs.FailErr(err, "read", name, "for binding", filepath.Base(dir))
+	}
+	return string(contents), nil
+}
+
+func readServicesFile(path string) ([]VCAPService, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, packs.FailErr(err, "read services file", path)
+	}
+	var file struct {
+		Services []struct {
+			Name         string      `yaml:"name"`
+			InstanceName string      `yaml:"instance_name"`
+			Label        string      `yaml:"label"`
+			Plan         string      `yaml:"plan"`
+			Tags         []string    `yaml:"tags"`
+			Credentials  interface{} `yaml:"credentials"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(contents, &file); err != nil {
+		return nil, packs.FailErr(err, "parse services file", path)
+	}
+	var services []VCAPService
+	for _, s := range file.Services {
+		creds, ok := jsonValue(s.Credentials).(map[string]interface{})
+		if s.Credentials != nil && !ok {
+			return nil, packs.FailErr(fmt.Errorf("credentials must be a map"), "parse service", s.Name)
+		}
+		services = append(services, VCAPService{
+			Name:         s.Name,
+			InstanceName: s.InstanceName,
+			Label:        s.Label,
+			Plan:         s.Plan,
+			Tags:         s.Tags,
+			Credentials:  creds,
+		})
+	}
+	return services, nil
+}
+
+// jsonValue converts the map[interface{}]interface{} values produced by
+// the YAML decoder into values that can be encoded as JSON.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for k, val := range v {
+			out[fmt.Sprintf("%v", k)] = jsonValue(val)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = jsonValue(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
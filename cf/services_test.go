@@ -0,0 +1,449 @@
+package cf_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cf"
+)
+
+func testServices(t *testing.T, when spec.G, it spec.S) {
+	var (
+		app    *cf.App
+		set    func(k, v string)
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if app, err = cf.New(); err != nil {
+			t.Fatalf("Failed to create app: %s\n", err)
+		}
+		app.Env, set = env()
+		if tmpDir, err = ioutil.TempDir("", "pack.services.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	when("a bindings directory is present", func() {
+		it("should build VCAP_SERVICES from each binding", func() {
+			bindings := filepath.Join(tmpDir, "bindings")
+			writeFile(t, filepath.Join(bindings, "some-db", "label"), "p-mysql\n")
+			writeFile(t, filepath.Join(bindings, "some-db", "plan"), "100mb\n")
+			writeFile(t, filepath.Join(bindings, "some-db", "tags"), "mysql\nrelational\n")
+			writeFile(t, filepath.Join(bindings, "some-db", "credentials.json"), `{"uri": "mysql://some-uri", "port": 3306}`)
+			writeFile(t, filepath.Join(bindings, "some-ups", "credentials.json"), `{"key": "value"}`)
+			set(packs.EnvServicesDir, bindings)
+
+			expected := `{
+				"p-mysql": [{
+					"binding_name": null,
+					"credentials": {"uri": "mysql://some-uri", "port": 3306},
+					"instance_name": "some-db",
+					"label": "p-mysql",
+					"name": "some-db",
+					"plan": "100mb",
+					"provider": null,
+					"syslog_drain_url": null,
+					"tags": ["mysql", "relational"],
+					"volume_mounts": []
+				}],
+				"user-provided": [{
+					"binding_name": null,
+					"credentials": {"key": "value"},
+					"instance_name": "some-ups",
+					"label": "user-provided",
+					"name": "some-ups",
+					"plan": "",
+					"provider": null,
+					"syslog_drain_url": null,
+					"tags": [],
+					"volume_mounts": []
+				}]
+			}`
+			jsonCmp(t, stage(t, app)["VCAP_SERVICES"], expected)
+			jsonCmp(t, launch(t, app)["VCAP_SERVICES"], expected)
+		})
+	})
+
+	when("a services file is set", func() {
+		it("should build VCAP_SERVICES from the file", func() {
+			servicesFile := filepath.Join(tmpDir, "services.yml")
+			writeFile(t, servicesFile, `
+services:
+- name: some-db
+  label: p-mysql
+  plan: 100mb
+  tags: [mysql]
+  credentials:
+    uri: mysql://some-uri
+    options: {ssl: true}
+`)
+			set(packs.EnvServicesFile, servicesFile)
+
+			expected := `{
+				"p-mysql": [{
+					"binding_name": null,
+					"credentials": {"uri": "mysql://some-uri", "options": {"ssl": true}},
+					"instance_name": "some-db",
+					"label": "p-mysql",
+					"name": "some-db",
+					"plan": "100mb",
+					"provider": null,
+					"syslog_drain_url": null,
+					"tags": ["mysql"],
+					"volume_mounts": []
+				}]
+			}`
+			jsonCmp(t, stage(t, app)["VCAP_SERVICES"], expected)
+			jsonCmp(t, launch(t, app)["VCAP_SERVICES"], expected)
+		})
+	})
+
+	when("the credentials are invalid", func() {
+		it("should return an error", func() {
+			bindings := filepath.Join(tmpDir, "bindings")
+			writeFile(t, filepath.Join(bindings, "some-db", "credentials.json"), `{`)
+			set(packs.EnvServicesDir, bindings)
+
+			if _, err := app.Services(); err == nil {
+				t.Fatal("Expected error")
+			}
+			if _, err := app.Launch(); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+
+	when("SERVICE_BINDING_ROOT contains projected bindings", func() {
+		it("should build VCAP_SERVICES from each binding", func() {
+			root := filepath.Join(tmpDir, "root")
+			writeFile(t, filepath.Join(root, "some-db", "type"), "postgresql\n")
+			writeFile(t, filepath.Join(root, "some-db", "provider"), "some-provider")
+			writeFile(t, filepath.Join(root, "some-db", "username"), "some-user")
+			writeFile(t, filepath.Join(root, "some-db", "..data", "username"), "hidden")
+			set("SERVICE_BINDING_ROOT", root)
+
+			jsonCmp(t, launch(t, app)["VCAP_SERVICES"], `{
+				"postgresql": [{
+					"binding_name": null,
+					"credentials": {"username": "some-user"},
+					"instance_name": "some-db",
+					"label": "postgresql",
+					"name": "some-db",
+					"plan": "",
+					"provider": "some-provider",
+					"syslog_drain_url": null,
+					"tags": ["postgresql"],
+					"volume_mounts": []
+				}]
+			}`)
+		})
+	})
+
+	when("there are no bindings", func() {
+		it("should set VCAP_SERVICES to an empty object", func() {
+			set(packs.EnvServicesDir, filepath.Join(tmpDir, "missing"))
+
+			jsonCmp(t, stage(t, app)["VCAP_SERVICES"], "{}")
+		})
+	})
+}
+
+func jsonCmp(t *testing.T, j1, j2 string) {
+	t.Helper()
+	var v1, v2 interface{}
+	if err := json.Unmarshal([]byte(j1), &v1); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := json.Unmarshal([]byte(j2), &v2); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if !reflect.DeepEqual(v1, v2) {
+		t.Fatalf("Mismatched JSON:\n%s\n!=\n%s\n", j1, j2)
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+}
+
+func testProjectServiceBindings(t *testing.T, when spec.G, it spec.S) {
+	var (
+		app    *cf.App
+		set    func(k, v string)
+		tmpDir string
+	)
+
+	it.Before(func() {
+		var err error
+		if app, err = cf.New(); err != nil {
+			t.Fatalf("Failed to create app: %s\n", err)
+		}
+		app.Env, set = env()
+		if tmpDir, err = ioutil.TempDir("", "pack.bindings.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should write VCAP_SERVICES to SERVICE_BINDING_ROOT", func() {
+		root := filepath.Join(tmpDir, "root")
+		set("SERVICE_BINDING_ROOT", root)
+		env := map[string]string{"VCAP_SERVICES": `{
+			"p-mysql": [{
+				"name": "some-db",
+				"label": "p-mysql",
+				"credentials": {"uri": "mysql://some-uri", "port": 3306}
+			}]
+		}`}
+
+		if err := app.ProjectServiceBindings(env); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+
+		if env["SERVICE_BINDING_ROOT"] != root {
+			t.Fatalf("Incorrect SERVICE_BINDING_ROOT: %s\n", env["SERVICE_BINDING_ROOT"])
+		}
+		if fi, err := os.Stat(filepath.Join(root, "some-db")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		} else if mode := fi.Mode().Perm(); mode != 0700 {
+			t.Fatalf("Incorrect binding mode: %o\n", mode)
+		}
+		for file, expected := range map[string]string{
+			"type": "p-mysql",
+			"uri":  "mysql://some-uri",
+			"port": "3306",
+		} {
+			path := filepath.Join(root, "some-db", file)
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if string(contents) != expected {
+				t.Fatalf("%s: %s != %s\n", file, contents, expected)
+			}
+			if fi, err := os.Stat(path); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if mode := fi.Mode().Perm(); mode != 0600 {
+				t.Fatalf("Incorrect file mode for %s: %o\n", file, mode)
+			}
+		}
+	})
+
+	when("SERVICE_BINDING_ROOT already contains bindings", func() {
+		it("should leave them unchanged", func() {
+			root := filepath.Join(tmpDir, "root")
+			writeFile(t, filepath.Join(root, "some-db", "type"), "postgresql")
+			set("SERVICE_BINDING_ROOT", root)
+			env := map[string]string{"VCAP_SERVICES": `{"p-mysql": [{"name": "other-db"}]}`}
+
+			if err := app.ProjectServiceBindings(env); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "other-db")); !os.IsNotExist(err) {
+				t.Fatalf("Unexpected binding written: %v\n", err)
+			}
+		})
+	})
+
+	when("the projection is read back", func() {
+		it("should keep every field of VCAP_SERVICES", func() {
+			root := filepath.Join(tmpDir, "root")
+			set("SERVICE_BINDING_ROOT", root)
+			vcapServices := `{
+				"p-mysql": [{
+					"binding_name": "some-binding",
+					"credentials": {"uri": "mysql://some-uri", "port": 3306, "tls": {"ca": "some-ca"}},
+					"instance_name": "some-instance",
+					"label": "p-mysql",
+					"name": "some-db",
+					"plan": "100mb",
+					"provider": "some-provider",
+					"syslog_drain_url": null,
+					"tags": ["mysql", "relational"],
+					"volume_mounts": []
+				}]
+			}`
+			if err := app.ProjectServiceBindings(map[string]string{"VCAP_SERVICES": vcapServices}); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+
+			jsonCmp(t, launch(t, app)["VCAP_SERVICES"], vcapServices)
+		})
+	})
+
+	when("SERVICE_BINDING_ROOT holds a previous projection", func() {
+		var root string
+
+		it.Before(func() {
+			root = filepath.Join(tmpDir, "root")
+			set("SERVICE_BINDING_ROOT", root)
+			env := map[string]string{"VCAP_SERVICES": `{"p-mysql": [{"name": "old-db", "credentials": {"key": "old-value"}}]}`}
+			if err := app.ProjectServiceBindings(env); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+		})
+
+		it("should replace it", func() {
+			env := map[string]string{"VCAP_SERVICES": `{"p-mysql": [{"name": "new-db", "credentials": {"key": "new-value"}}]}`}
+
+			if err := app.ProjectServiceBindings(env); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "old-db")); !os.IsNotExist(err) {
+				t.Fatalf("Stale binding not removed: %v\n", err)
+			}
+			if contents, err := ioutil.ReadFile(filepath.Join(root, "new-db", "key")); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if string(contents) != "new-value" {
+				t.Fatalf("Incorrect credential: %s\n", contents)
+			}
+			checkTempDirs(t, tmpDir)
+		})
+
+		it("should remove it when there are no services", func() {
+			if err := app.ProjectServiceBindings(map[string]string{"VCAP_SERVICES": "{}"}); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+
+			if _, err := os.Stat(root); !os.IsNotExist(err) {
+				t.Fatalf("Stale projection not removed: %v\n", err)
+			}
+		})
+
+		it("should leave it unchanged if the new projection fails", func() {
+			env := map[string]string{"VCAP_SERVICES": `{
+				"p-mysql": [{"name": "new-db", "credentials": {"key": "value"}}],
+				"user-provided": [{"name": "other-db", "credentials": {"type": "some-type"}}]
+			}`}
+
+			if err := app.ProjectServiceBindings(env); err == nil {
+				t.Fatal("Expected error")
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "old-db", "key")); err != nil {
+				t.Fatalf("Previous projection changed: %s\n", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "new-db")); !os.IsNotExist(err) {
+				t.Fatalf("Partial projection written: %v\n", err)
+			}
+			checkTempDirs(t, tmpDir)
+		})
+	})
+
+	when("two services have the same name", func() {
+		it("should fail without writing bindings", func() {
+			root := filepath.Join(tmpDir, "root")
+			set("SERVICE_BINDING_ROOT", root)
+			env := map[string]string{"VCAP_SERVICES": `{
+				"p-mysql": [{"name": "some-db"}],
+				"user-provided": [{"name": "some-db"}]
+			}`}
+
+			err := app.ProjectServiceBindings(env)
+			if err == nil || !strings.Contains(err.Error(), "p-mysql/some-db") || !strings.Contains(err.Error(), "user-provided/some-db") {
+				t.Fatalf("Incorrect error: %v\n", err)
+			}
+			if _, err := os.Stat(root); !os.IsNotExist(err) {
+				t.Fatalf("Unexpected bindings written: %v\n", err)
+			}
+		})
+	})
+
+	when("credentials are resolved from a credential source", func() {
+		it("should only write the credhub-ref to the bindings", func() {
+			root := filepath.Join(tmpDir, "root")
+			set("SERVICE_BINDING_ROOT", root)
+			creds := filepath.Join(tmpDir, "credentials.yml")
+			writeFile(t, creds, "/c/some-db:\n  password: some-secret\n")
+			source, err := cf.NewCredentialSource(creds, "")
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			env := map[string]string{"VCAP_SERVICES": `{
+				"p-mysql": [{"name": "some-db", "credentials": {"credhub-ref": "/c/some-db"}}]
+			}`}
+
+			if err := app.ProvideServices(env, source); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+
+			jsonCmp(t, env["VCAP_SERVICES"], `{
+				"p-mysql": [{"name": "some-db", "credentials": {"password": "some-secret"}}]
+			}`)
+			var files int
+			err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+				if err != nil || fi.IsDir() {
+					return err
+				}
+				files++
+				contents, err := ioutil.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				if strings.Contains(string(contents), "some-secret") {
+					t.Fatalf("Resolved credential written to %s\n", path)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if files == 0 {
+				t.Fatal("Expected bindings to be written")
+			}
+		})
+	})
+
+	when("there are no services", func() {
+		it("should not set SERVICE_BINDING_ROOT", func() {
+			set("SERVICE_BINDING_ROOT", filepath.Join(tmpDir, "root"))
+			env := map[string]string{"VCAP_SERVICES": "{}"}
+
+			if err := app.ProjectServiceBindings(env); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+
+			if root, ok := env["SERVICE_BINDING_ROOT"]; ok {
+				t.Fatalf("Unexpected SERVICE_BINDING_ROOT: %s\n", root)
+			}
+		})
+	})
+}
+
+func checkTempDirs(t *testing.T, dir string) {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			t.Fatalf("Temporary directory left behind: %s\n", f.Name())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cf
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/buildpack/packs"
+)
+
+const (
+	DefaultStack  = "cflinuxfs2"
+	osReleasePath = "etc/os-release"
+)
+
+var stackCodenames = map[string]string{
+	"trusty": "cflinuxfs2",
+	"bionic": "cflinuxfs3",
+}
+
+// Stack returns the name of the CF stack that the app runs on.
+func (a *App) Stack() string {
+	return a.envStr(packs.EnvStackID, a.stack)
+}
+
+// RunImage returns the image repository containing the run image for stack.
+func RunImage(stack string) string {
+	return "packs/" + stack + ":run"
+}
+
+// DetectStack returns the CF stack corresponding to the OS release found
+// under root, or DefaultStack with a warning if the release is not a
+// supported CF stack.
+func DetectStack(root string) (string, error) {
+	f, err := os.Open(filepath.Join(root, osReleasePath))
+	if os.IsNotExist(err) {
+		return DefaultStack, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	var codename string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 || kv[0] != "VERSION_CODENAME" && kv[0] != "UBUNTU_CODENAME" {
+			continue
+		}
+		codename = strings.Trim(kv[1], `"'`)
+		if stack, ok := stackCodenames[codename]; ok {
+			return stack, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	log.Printf("Warning: using %s stack for unsupported OS release %s\n", DefaultStack, strconv.Quote(codename))
+	return DefaultStack, nil
+}
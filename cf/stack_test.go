@@ -0,0 +1,84 @@
+package cf_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+	"github.com/buildpack/packs/cf"
+)
+
+func testStack(t *testing.T, when spec.G, it spec.S) {
+	var root string
+
+	it.Before(func() {
+		var err error
+		if root, err = ioutil.TempDir("", "pack.stack.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(root)
+	})
+
+	for _, tt := range []struct {
+		name, osRelease, stack string
+	}{
+		{"trusty", "NAME=\"Ubuntu\"\nVERSION_CODENAME=trusty\n", "cflinuxfs2"},
+		{"bionic", "NAME=\"Ubuntu\"\nVERSION_CODENAME=bionic\nUBUNTU_CODENAME=bionic\n", "cflinuxfs3"},
+		{"quoted codename", "UBUNTU_CODENAME=\"bionic\"\n", "cflinuxfs3"},
+		{"unsupported codename", "VERSION_CODENAME=jammy\n", cf.DefaultStack},
+		{"unknown codename", "VERSION_CODENAME=bookworm\n", cf.DefaultStack},
+	} {
+		tt := tt
+		when("the os-release codename is "+tt.name, func() {
+			it("should return "+tt.stack, func() {
+				writeFile(t, filepath.Join(root, "etc", "os-release"), tt.osRelease)
+				if s, err := cf.DetectStack(root); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				} else if s != tt.stack {
+					t.Fatalf("Incorrect stack: %s != %s\n", s, tt.stack)
+				}
+			})
+		})
+	}
+
+	when("there is no os-release file", func() {
+		it("should return the default stack", func() {
+			if s, err := cf.DetectStack(root); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if s != cf.DefaultStack {
+				t.Fatalf("Incorrect stack: %s\n", s)
+			}
+		})
+	})
+
+	when("a run image is needed for a stack", func() {
+		it("should return the packs run image for the stack", func() {
+			if image := cf.RunImage("cflinuxfs3"); image != "packs/cflinuxfs3:run" {
+				t.Fatalf("Incorrect run image: %s\n", image)
+			}
+		})
+	})
+
+	when("the stack is set in the env", func() {
+		it("should set CF_STACK to the provided stack", func() {
+			app, err := cf.New()
+			if err != nil {
+				t.Fatalf("Failed to create app: %s\n", err)
+			}
+			var set func(k, v string)
+			app.Env, set = env()
+			set(packs.EnvStackID, "cflinuxfs3")
+
+			if s := stage(t, app)["CF_STACK"]; s != "cflinuxfs3" {
+				t.Fatalf("Incorrect stack: %s\n", s)
+			}
+		})
+	})
+}
@@ -0,0 +1,52 @@
+package packs
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+type Buildpack struct {
+	Name string
+	Dir  string
+}
+
+type DetectResult struct {
+	Name     string `json:"name"`
+	Detected bool   `json:"detected"`
+	Output   string `json:"output"`
+	Error    string `json:"error,omitempty"`
+}
+
+func Detect(appDir string, buildpacks []Buildpack, run func(*exec.Cmd) error) []DetectResult {
+	if run == nil {
+		run = (*exec.Cmd).Run
+	}
+	var results []DetectResult
+	for _, bp := range buildpacks {
+		out := &bytes.Buffer{}
+		cmd := exec.Command(filepath.Join(bp.Dir, "bin", "detect"), appDir)
+		cmd.Dir = appDir
+		cmd.Stdout = out
+		cmd.Stderr = out
+		result := DetectResult{Name: bp.Name}
+		err := run(cmd)
+		if _, ok := err.(*exec.ExitError); err != nil && !ok {
+			result.Error = err.Error()
+		}
+		result.Detected = err == nil
+		result.Output = strings.TrimSpace(out.String())
+		results = append(results, result)
+	}
+	return results
+}
+
+func Detected(results []DetectResult) bool {
+	for _, r := range results {
+		if r.Detected {
+			return true
+		}
+	}
+	return false
+}
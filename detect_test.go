@@ -0,0 +1,107 @@
+package packs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+)
+
+func TestDetect(t *testing.T) {
+	spec.Run(t, "#Detect", testDetect)
+}
+
+func testDetect(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir, appDir string
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.detect.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		appDir = filepath.Join(tmpDir, "app")
+		if err := os.Mkdir(appDir, 0777); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	buildpack := func(name, script string) packs.Buildpack {
+		t.Helper()
+		dir := filepath.Join(tmpDir, "buildpacks", name)
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), 0777); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "bin", "detect"), []byte("#!/bin/sh\n"+script), 0777); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		return packs.Buildpack{Name: name, Dir: dir}
+	}
+
+	it("should report the result of each buildpack in order", func() {
+		results := packs.Detect(appDir, []packs.Buildpack{
+			buildpack("some-bp", "echo some-output\nexit 1"),
+			buildpack("other-bp", `echo "other-output $1"; touch "$PWD/detected"`),
+			buildpack("last-bp", "echo last-output"),
+		}, nil)
+		expected := []packs.DetectResult{
+			{Name: "some-bp", Detected: false, Output: "some-output"},
+			{Name: "other-bp", Detected: true, Output: "other-output " + appDir},
+			{Name: "last-bp", Detected: true, Output: "last-output"},
+		}
+		if !reflect.DeepEqual(results, expected) {
+			t.Fatalf("Incorrect results:\n%+v\n!=\n%+v\n", results, expected)
+		}
+		if _, err := os.Stat(filepath.Join(appDir, "detected")); err != nil {
+			t.Fatal("Expected detect to run in the app dir")
+		}
+		if !packs.Detected(results) {
+			t.Fatal("Expected detection to pass")
+		}
+	})
+
+	it("should fail detection if no buildpack passes", func() {
+		results := packs.Detect(appDir, []packs.Buildpack{
+			buildpack("some-bp", "exit 1"),
+			buildpack("other-bp", "exit 100"),
+		}, nil)
+		if len(results) != 2 || results[0].Detected || results[1].Detected {
+			t.Fatalf("Incorrect results: %+v\n", results)
+		}
+		if packs.Detected(results) {
+			t.Fatal("Expected detection to fail")
+		}
+	})
+
+	it("should report buildpacks that cannot be run", func() {
+		results := packs.Detect(appDir, []packs.Buildpack{
+			{Name: "missing-bp", Dir: filepath.Join(tmpDir, "missing-bp")},
+		}, nil)
+		if len(results) != 1 || results[0].Detected || results[0].Error == "" {
+			t.Fatalf("Incorrect results: %+v\n", results)
+		}
+	})
+
+	it("should run each detect command with the provided func", func() {
+		var ran []string
+		packs.Detect(appDir, []packs.Buildpack{
+			buildpack("some-bp", "exit 0"),
+			buildpack("other-bp", "exit 0"),
+		}, func(cmd *exec.Cmd) error {
+			ran = append(ran, filepath.Base(filepath.Dir(filepath.Dir(cmd.Path))))
+			return cmd.Run()
+		})
+		if !reflect.DeepEqual(ran, []string{"some-bp", "other-bp"}) {
+			t.Fatalf("Incorrect commands: %v\n", ran)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+// Package droplet verifies and extracts droplets, the compressed archives
+// that contain a staged app.
+package droplet
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Digest returns the hex-encoded sha256 digest and the size of the file at
+// path.
+func Digest(path string) (sha string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	r := NewReader(f)
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return "", 0, err
+	}
+	return r.Digest(), r.Size(), nil
+}
+
+// VerifyError reports a droplet that does not match its recorded digest
+// or size.
+type VerifyError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%s does not match: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+// Verify returns a *VerifyError if the file at path does not have the
+// given sha256 digest, or the given size if size is not zero.
+func Verify(path, sha string, size int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return NewReader(f).Verify(path, sha, size)
+}
+
+// Reader computes the digest and size of a droplet as it is read, so that
+// a droplet can be verified while it is streamed.
+type Reader struct {
+	r    io.Reader
+	hash hash.Hash
+	size int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, hash: sha256.New()}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
+
+// Digest returns the hex-encoded sha256 digest of the bytes read so far.
+func (r *Reader) Digest() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
+
+// Size returns the number of bytes read so far.
+func (r *Reader) Size() int64 {
+	return r.size
+}
+
+// Verify reads the rest of the droplet and returns a *VerifyError, which
+// refers to the droplet as name, if it does not have the given sha256
+// digest, or the given size if size is not zero.
+func (r *Reader) Verify(name, sha string, size int64) error {
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return err
+	}
+	if size != 0 && r.size != size {
+		return &VerifyError{Path: name, Expected: fmt.Sprintf("%d bytes", size), Actual: fmt.Sprintf("%d bytes", r.size)}
+	}
+	if actual := r.Digest(); !strings.EqualFold(actual, sha) {
+		return &VerifyError{Path: name, Expected: "sha256:" + sha, Actual: "sha256:" + actual}
+	}
+	return nil
+}
+
+// CheckHeader returns an error if the entry described by hdr is absolute,
+// contains .. or is a hard link to a file outside of the root of the
+// droplet. Symlinks may point anywhere, since they are only followed when
+// the droplet is run; Extract rejects entries written through them.
+func CheckHeader(hdr *tar.Header) error {
+	if path.IsAbs(hdr.Name) {
+		return fmt.Errorf("invalid absolute path in droplet: %s", hdr.Name)
+	}
+	for _, part := range strings.Split(hdr.Name, "/") {
+		if part == ".." {
+			return fmt.Errorf("invalid path in droplet: %s", hdr.Name)
+		}
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		if path.IsAbs(hdr.Linkname) || escapes(hdr.Linkname) {
+			return fmt.Errorf("invalid hard link in droplet: %s -> %s", hdr.Name, hdr.Linkname)
+		}
+	}
+	return nil
+}
+
+func escapes(name string) bool {
+	name = path.Clean(name)
+	return name == ".." || strings.HasPrefix(name, "../")
+}
+
+// Extract extracts the gzipped tarball r into dir, as ExtractTar does.
+func Extract(r io.Reader, dir string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+	return ExtractTar(gzr, dir)
+}
+
+// ExtractTar extracts the tarball r into dir, creating dir if necessary.
+// Entries that fail CheckHeader, or that would be written outside of dir
+// through a symlink extracted earlier, are rejected. Directory permissions
+// and modification times are restored last, so that read-only directories
+// can be populated.
+func ExtractTar(r io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	type dirHeader struct {
+		path string
+		hdr  *tar.Header
+	}
+	var dirs []dirHeader
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := CheckHeader(hdr); err != nil {
+			return err
+		}
+		name := filepath.FromSlash(path.Clean(hdr.Name))
+		if name == "." {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			target, err := resolveDir(root, name)
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, dirHeader{target, hdr})
+			continue
+		}
+		parent, err := resolveDir(root, filepath.Dir(name))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(parent, filepath.Base(name))
+		mode := hdr.FileInfo().Mode()
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(target, tr, mode.Perm()); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			source := filepath.FromSlash(path.Clean(hdr.Linkname))
+			sourceDir, err := resolveDir(root, filepath.Dir(source))
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Link(filepath.Join(sourceDir, filepath.Base(source)), target); err != nil {
+				return err
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := os.Chmod(d.path, d.hdr.FileInfo().Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(d.path, d.hdr.ModTime, d.hdr.ModTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveDir creates the directory name relative to root one component at
+// a time, and returns its path with any symlinks resolved. It fails before
+// creating anything outside of root if a component resolves outside of it.
+func resolveDir(root, name string) (string, error) {
+	dir := root
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		if part == "" || part == "." {
+			continue
+		}
+		next := filepath.Join(dir, part)
+		if err := os.Mkdir(next, 0777); err != nil && !os.IsExist(err) {
+			return "", err
+		}
+		resolved, err := filepath.EvalSymlinks(next)
+		if err != nil {
+			return "", err
+		}
+		if !within(root, resolved) {
+			return "", fmt.Errorf("invalid path in droplet: %s resolves outside of droplet", name)
+		}
+		dir = resolved
+	}
+	return dir, nil
+}
+
+func within(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+func writeFile(path string, r io.Reader, perm os.FileMode) error {
+	os.Remove(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(path, perm)
+}
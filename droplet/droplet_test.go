@@ -0,0 +1,218 @@
+package droplet_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/droplet"
+)
+
+func TestDroplet(t *testing.T) {
+	spec.Run(t, "#Verify", testVerify)
+	spec.Run(t, "#Extract", testExtract)
+	spec.Run(t, "#Open", testOpen)
+}
+
+func testVerify(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir, path string
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.droplet.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		path = filepath.Join(tmpDir, "droplet.tgz")
+		if err := ioutil.WriteFile(path, []byte("some-droplet"), 0666); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should succeed if the digest and size match", func() {
+		sha, size, err := droplet.Digest(path)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if expected := fmt.Sprintf("%x", sha256.Sum256([]byte("some-droplet"))); sha != expected {
+			t.Fatalf("Incorrect digest: %s != %s\n", sha, expected)
+		}
+		if size != int64(len("some-droplet")) {
+			t.Fatalf("Incorrect size: %d\n", size)
+		}
+		if err := droplet.Verify(path, sha, size); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it("should return a *VerifyError if the digest does not match", func() {
+		_, size, err := droplet.Digest(path)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		err = droplet.Verify(path, "0000000000000000000000000000000000000000000000000000000000000000", size)
+		if _, ok := err.(*droplet.VerifyError); !ok {
+			t.Fatalf("Expected *VerifyError, got: %#v\n", err)
+		}
+	})
+
+	it("should return a *VerifyError if the size does not match", func() {
+		sha, _, err := droplet.Digest(path)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if _, ok := droplet.Verify(path, sha, 1).(*droplet.VerifyError); !ok {
+			t.Fatal("Expected *VerifyError")
+		}
+	})
+}
+
+func testExtract(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir, dir string
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.droplet.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		dir = filepath.Join(tmpDir, "home", "vcap")
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should extract files, directories and links", func() {
+		tgz := tarball(t,
+			&tar.Header{Name: "./app/", Typeflag: tar.TypeDir, Mode: 0555},
+			&tar.Header{Name: "./app/some-file", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len("some-contents"))},
+			&tar.Header{Name: "./app/some-link", Typeflag: tar.TypeSymlink, Linkname: "some-file"},
+			&tar.Header{Name: "./app/other-file", Typeflag: tar.TypeLink, Linkname: "./app/some-file"},
+			&tar.Header{Name: "logs/", Typeflag: tar.TypeDir, Mode: 0755},
+		)
+		if err := droplet.Extract(tgz, dir); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		for _, name := range []string{"some-file", "some-link", "other-file"} {
+			if contents, err := ioutil.ReadFile(filepath.Join(dir, "app", name)); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if string(contents) != "some-contents" {
+				t.Fatalf("Incorrect contents of %s: %s\n", name, contents)
+			}
+		}
+		if fi, err := os.Stat(filepath.Join(dir, "app")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		} else if fi.Mode().Perm() != 0555 {
+			t.Fatalf("Incorrect mode: %s\n", fi.Mode())
+		}
+		os.Chmod(filepath.Join(dir, "app"), 0755)
+	})
+
+	it("should extract symlinks that point outside of the droplet", func() {
+		tgz := tarball(t,
+			&tar.Header{Name: "app/bin", Typeflag: tar.TypeSymlink, Linkname: "/home/vcap/deps/0/bin"},
+			&tar.Header{Name: "app/lib", Typeflag: tar.TypeSymlink, Linkname: "../../some-dir"},
+		)
+		if err := droplet.Extract(tgz, dir); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		for name, expected := range map[string]string{
+			"bin": "/home/vcap/deps/0/bin",
+			"lib": "../../some-dir",
+		} {
+			if link, err := os.Readlink(filepath.Join(dir, "app", name)); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if link != expected {
+				t.Fatalf("Incorrect link for %s: %s != %s\n", name, link, expected)
+			}
+		}
+	})
+
+	for _, tt := range []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{"an absolute path", []*tar.Header{
+			{Name: "/etc/some-file", Typeflag: tar.TypeReg, Mode: 0644},
+		}},
+		{"a .. entry", []*tar.Header{
+			{Name: "app/../../some-file", Typeflag: tar.TypeReg, Mode: 0644},
+		}},
+		{"a hard link outside of the droplet", []*tar.Header{
+			{Name: "some-link", Typeflag: tar.TypeLink, Linkname: "../some-file"},
+		}},
+		{"a file written through a symlink outside of the droplet", []*tar.Header{
+			{Name: "some-link", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "some-link/some-file", Typeflag: tar.TypeReg, Mode: 0644},
+		}},
+		{"a directory created through a symlink outside of the droplet", []*tar.Header{
+			{Name: "app/some-link", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+			{Name: "app/some-link/some-dir/some-file", Typeflag: tar.TypeReg, Mode: 0644},
+		}},
+		{"a directory entry for a symlink outside of the droplet", []*tar.Header{
+			{Name: "some-link", Typeflag: tar.TypeSymlink, Linkname: ".."},
+			{Name: "some-link/", Typeflag: tar.TypeDir, Mode: 0700},
+		}},
+	} {
+		tt := tt
+		when("the droplet contains "+tt.name, func() {
+			it("should return an error without writing outside of the droplet", func() {
+				if err := droplet.Extract(tarball(t, tt.headers...), dir); err == nil {
+					t.Fatal("Expected error")
+				}
+				for _, path := range []string{
+					filepath.Join(tmpDir, "some-file"),
+					filepath.Join(tmpDir, "home", "some-file"),
+					filepath.Join(tmpDir, "home", "some-dir"),
+				} {
+					if _, err := os.Lstat(path); !os.IsNotExist(err) {
+						t.Fatalf("Unexpected file: %s\n", path)
+					}
+				}
+			})
+		})
+	}
+}
+
+// tarball returns a gzipped tarball with the given entries, where every
+// regular file contains "some-contents".
+func tarball(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len("some-contents"))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte("some-contents")); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	return buf
+}
@@ -0,0 +1,114 @@
+package droplet
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// IsRemote returns true if location is not a local file, so that Open
+// streams the droplet from a URL or registry.
+func IsRemote(location string) bool {
+	if isURL(location) {
+		return true
+	}
+	if _, err := os.Stat(location); err == nil {
+		return false
+	}
+	_, err := parseReference(location)
+	return err == nil
+}
+
+// Open returns the compressed droplet at location, which may be a local
+// path, an http(s) URL, or a reference to an image with the droplet as its
+// last layer. If auth is not empty, it is sent as the Authorization header
+// to URLs and registries. Otherwise, registry credentials are read from the
+// Docker config.
+func Open(location, auth string) (io.ReadCloser, error) {
+	if isURL(location) {
+		return openURL(location, auth)
+	}
+	if !IsRemote(location) {
+		return os.Open(location)
+	}
+	return openImage(location, auth)
+}
+
+// client bounds the whole download, including the streamed droplet.
+var client = &http.Client{Timeout: 15 * time.Minute}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+func openURL(url, auth string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// parseReference only accepts references with an explicit registry, so
+// that missing local paths are not mistaken for images on Docker Hub.
+func parseReference(location string) (name.Reference, error) {
+	ref, err := name.ParseReference(location, name.WeakValidation)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(location, "/", 2)
+	if len(parts) != 2 || !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return nil, fmt.Errorf("no registry in image reference %s", location)
+	}
+	return ref, nil
+}
+
+func openImage(location, auth string) (io.ReadCloser, error) {
+	ref, err := parseReference(location)
+	if err != nil {
+		return nil, err
+	}
+	var authenticator authn.Authenticator = headerAuth(auth)
+	if auth == "" {
+		if authenticator, err = authn.DefaultKeychain.Resolve(ref.Context().Registry); err != nil {
+			return nil, err
+		}
+	}
+	image, err := remote.Image(ref, remote.WithAuth(authenticator))
+	if err != nil {
+		return nil, err
+	}
+	layers, err := image.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no droplet layer in %s", location)
+	}
+	return layers[len(layers)-1].Compressed()
+}
+
+// headerAuth authenticates to a registry with a fixed Authorization header.
+type headerAuth string
+
+func (h headerAuth) Authorization() (string, error) {
+	return string(h), nil
+}
@@ -0,0 +1,178 @@
+package droplet_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/droplet"
+)
+
+func testOpen(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir   string
+		contents []byte
+		sha      string
+		server   *httptest.Server
+		auth     string
+	)
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.droplet.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		contents = tarball(t, &tar.Header{Name: "app/some-file", Typeflag: tar.TypeReg, Mode: 0644}).Bytes()
+		sha = fmt.Sprintf("%x", sha256.Sum256(contents))
+		auth = ""
+		server = httptest.NewServer(newFakeRegistry(t, "some-app", contents, &auth))
+	})
+
+	it.After(func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	})
+
+	extract := func(location, auth string) *droplet.Reader {
+		t.Helper()
+		rc, err := droplet.Open(location, auth)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		defer rc.Close()
+		r := droplet.NewReader(rc)
+		if err := droplet.Extract(r, tmpDir); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := r.Verify(location, sha, int64(len(contents))); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if out, err := ioutil.ReadFile(filepath.Join(tmpDir, "app", "some-file")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		} else if string(out) != "some-contents" {
+			t.Fatalf("Incorrect contents: %s\n", out)
+		}
+		return r
+	}
+
+	it("should open a local droplet", func() {
+		path := filepath.Join(tmpDir, "droplet.tgz")
+		if err := ioutil.WriteFile(path, contents, 0666); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if droplet.IsRemote(path) {
+			t.Fatal("Expected local droplet")
+		}
+		extract(path, "")
+	})
+
+	it("should stream a droplet from a URL with the auth header", func() {
+		auth = "Bearer some-token"
+		location := server.URL + "/droplets/some-app.tgz"
+		if !droplet.IsRemote(location) {
+			t.Fatal("Expected remote droplet")
+		}
+		extract(location, "Bearer some-token")
+	})
+
+	it("should stream a droplet from the last layer of an image", func() {
+		auth = "Basic c29tZS11c2VyOnNvbWUtcGFzc3dvcmQ="
+		location := strings.TrimPrefix(server.URL, "http://") + "/some-app:droplet"
+		if !droplet.IsRemote(location) {
+			t.Fatal("Expected remote droplet")
+		}
+		extract(location, auth)
+	})
+
+	it("should return an error if the auth header is rejected", func() {
+		auth = "Bearer some-token"
+		if _, err := droplet.Open(server.URL+"/droplets/some-app.tgz", "Bearer other-token"); err == nil {
+			t.Fatal("Expected error")
+		}
+	})
+
+	it("should detect a modified remote droplet", func() {
+		rc, err := droplet.Open(server.URL+"/droplets/some-app.tgz", "")
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		defer rc.Close()
+		r := droplet.NewReader(rc)
+		if err := droplet.Extract(r, tmpDir); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if _, ok := r.Verify("some-app.tgz", fmt.Sprintf("%x", sha256.Sum256(nil)), 0).(*droplet.VerifyError); !ok {
+			t.Fatal("Expected *VerifyError")
+		}
+	})
+
+	it("should not treat a missing relative path as an image", func() {
+		for _, path := range []string{"droplet.tgz", "some-dir/droplet.tgz"} {
+			if droplet.IsRemote(path) {
+				t.Fatalf("Expected %s to be local\n", path)
+			}
+		}
+	})
+}
+
+// newFakeRegistry serves droplet at /droplets/<repo>.tgz and as the only
+// layer of <repo>:droplet using the registry API. If *auth is not empty,
+// requests must use it as the Authorization header.
+func newFakeRegistry(t *testing.T, repo string, droplet []byte, auth *string) http.Handler {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(droplet))
+	config := []byte(`{"rootfs": {"type": "layers", "diff_ids": []}}`)
+	configDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(config))
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.docker.distribution.manifest.v2+json",
+		"config": map[string]interface{}{
+			"mediaType": "application/vnd.docker.container.image.v1+json",
+			"size":      len(config),
+			"digest":    configDigest,
+		},
+		"layers": []map[string]interface{}{{
+			"mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip",
+			"size":      len(droplet),
+			"digest":    digest,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	routes := map[string][]byte{
+		"/droplets/" + repo + ".tgz":             droplet,
+		"/v2/" + repo + "/manifests/droplet":     manifest,
+		"/v2/" + repo + "/blobs/" + digest:       droplet,
+		"/v2/" + repo + "/blobs/" + configDigest: config,
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *auth != "" && r.Header.Get("Authorization") != *auth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="some-realm"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		}
+		bytes.NewReader(body).WriteTo(w)
+	})
+}
@@ -16,6 +16,7 @@ type cmpMap []struct {
 func TestApp(t *testing.T) {
 	spec.Run(t, "#Stage", testStage)
 	spec.Run(t, "#Launch", testLaunch)
+	spec.Run(t, "#BuildEnv", testBuildEnv)
 }
 
 func testStage(t *testing.T, when spec.G, it spec.S) {
@@ -0,0 +1,314 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/buildpack/packs"
+)
+
+const (
+	appJSONFile = "app.json"
+	dotEnvFile  = ".env"
+
+	buildEnvPrefix    = "PACK_BUILD_ENV_"
+	buildSecretPrefix = "PACK_BUILD_SECRET_"
+)
+
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// BuildEnv contains the config vars passed to buildpacks through the env
+// dir during the build.
+type BuildEnv struct {
+	Vars      map[string]string
+	BuildOnly map[string]bool
+}
+
+// ReadBuildEnv returns the config vars for the build: the env section of
+// app.json in appDir, overridden by .env in appDir, overridden by the
+// PACK_BUILD_ENV_<NAME> and PACK_BUILD_SECRET_<NAME> vars in environ.
+// Secrets and app.json vars with build_only set are build-only, so they
+// are removed by Scrub after the build. build_only is a packs extension to
+// the app.json env schema, and is ignored by Heroku.
+func ReadBuildEnv(appDir string, environ []string) (*BuildEnv, error) {
+	b := &BuildEnv{Vars: map[string]string{}, BuildOnly: map[string]bool{}}
+	if err := b.readAppJSON(filepath.Join(appDir, appJSONFile)); err != nil {
+		return nil, err
+	}
+	dotEnv, err := readDotEnv(filepath.Join(appDir, dotEnvFile))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range dotEnv {
+		b.Vars[k] = v
+	}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if name := strings.TrimPrefix(parts[0], buildEnvPrefix); name != parts[0] {
+			b.Vars[name] = parts[1]
+		} else if name := strings.TrimPrefix(parts[0], buildSecretPrefix); name != parts[0] {
+			b.Vars[name] = parts[1]
+			b.BuildOnly[name] = true
+		}
+	}
+	for name := range b.Vars {
+		if !validName.MatchString(name) {
+			return nil, packs.FailCode(packs.CodeInvalidEnv, "use invalid config var name", strconv.Quote(name))
+		}
+	}
+	return b, nil
+}
+
+func (b *BuildEnv) readAppJSON(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return packs.FailErr(err, "read", path)
+	}
+	var appJSON struct {
+		Env map[string]json.RawMessage `json:"env"`
+	}
+	if err := json.Unmarshal(contents, &appJSON); err != nil {
+		return packs.FailErr(err, "parse", path)
+	}
+	for name, raw := range appJSON.Env {
+		var value string
+		if err := json.Unmarshal(raw, &value); err == nil {
+			b.Vars[name] = value
+			continue
+		}
+		var entry struct {
+			Value     *string `json:"value"`
+			BuildOnly bool    `json:"build_only"`
+		}
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return packs.FailErr(err, "parse", name, "in", path)
+		}
+		if entry.Value != nil {
+			b.Vars[name] = *entry.Value
+		}
+		if entry.BuildOnly {
+			b.BuildOnly[name] = true
+		}
+	}
+	return nil
+}
+
+// WriteDir writes each config var to a file in dir named after the var,
+// as expected by bin/compile.
+func (b *BuildEnv) WriteDir(dir string) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return packs.FailErr(err, "make directory", dir)
+	}
+	for name, value := range b.Vars {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0600); err != nil {
+			return packs.FailErr(err, "write config var", name)
+		}
+	}
+	return nil
+}
+
+// Scrub removes the build-only vars from envDir, and from app.json, .env and
+// the config_vars in the release metadata in appDir, so that they are not
+// included in the slug.
+func (b *BuildEnv) Scrub(envDir, appDir, releaseFile string) error {
+	if len(b.BuildOnly) == 0 {
+		return nil
+	}
+	for name := range b.BuildOnly {
+		if err := os.Remove(filepath.Join(envDir, name)); err != nil && !os.IsNotExist(err) {
+			return packs.FailErr(err, "remove config var", name)
+		}
+	}
+	if err := b.scrubAppJSON(filepath.Join(appDir, appJSONFile)); err != nil {
+		return err
+	}
+	if err := b.scrubDotEnv(filepath.Join(appDir, dotEnvFile)); err != nil {
+		return err
+	}
+	return b.scrubRelease(filepath.Join(appDir, releaseFile))
+}
+
+// scrubAppJSON removes the value of each build-only var in the env section
+// of app.json, leaving the rest of the entry in place.
+func (b *BuildEnv) scrubAppJSON(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return packs.FailErr(err, "read", path)
+	}
+	var appJSON map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &appJSON); err != nil {
+		return packs.FailErr(err, "parse", path)
+	}
+	var env map[string]json.RawMessage
+	if raw, ok := appJSON["env"]; ok {
+		if err := json.Unmarshal(raw, &env); err != nil {
+			return packs.FailErr(err, "parse env in", path)
+		}
+	}
+	scrubbed := false
+	for name, raw := range env {
+		var entry map[string]json.RawMessage
+		if !b.BuildOnly[name] || json.Unmarshal(raw, &entry) != nil {
+			continue
+		}
+		if _, ok := entry["value"]; !ok {
+			continue
+		}
+		delete(entry, "value")
+		if env[name], err = json.Marshal(entry); err != nil {
+			return packs.FailErr(err, "encode", name, "in", path)
+		}
+		scrubbed = true
+	}
+	if !scrubbed {
+		return nil
+	}
+	if appJSON["env"], err = json.Marshal(env); err != nil {
+		return packs.FailErr(err, "encode env in", path)
+	}
+	out, err := json.MarshalIndent(appJSON, "", "  ")
+	if err != nil {
+		return packs.FailErr(err, "encode", path)
+	}
+	return rewriteFile(path, append(out, '\n'))
+}
+
+func (b *BuildEnv) scrubDotEnv(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return packs.FailErr(err, "read", path)
+	}
+	out := &bytes.Buffer{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		if name, _, ok, _ := parseDotEnvLine(scanner.Text()); ok && b.BuildOnly[name] {
+			continue
+		}
+		fmt.Fprintln(out, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return packs.FailErr(err, "read", path)
+	}
+	return rewriteFile(path, out.Bytes())
+}
+
+func (b *BuildEnv) scrubRelease(path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return packs.FailErr(err, "read", path)
+	}
+	var release yaml.MapSlice
+	if err := yaml.Unmarshal(contents, &release); err != nil {
+		return packs.FailErr(err, "parse", path)
+	}
+	for i, item := range release {
+		configVars, ok := item.Value.(yaml.MapSlice)
+		if item.Key != "config_vars" || !ok {
+			continue
+		}
+		var scrubbed yaml.MapSlice
+		for _, v := range configVars {
+			if name, ok := v.Key.(string); !ok || !b.BuildOnly[name] {
+				scrubbed = append(scrubbed, v)
+			}
+		}
+		release[i].Value = scrubbed
+	}
+	out, err := yaml.Marshal(release)
+	if err != nil {
+		return packs.FailErr(err, "encode", path)
+	}
+	return rewriteFile(path, out)
+}
+
+// rewriteFile replaces the contents of the file at path, keeping its mode.
+func rewriteFile(path string, contents []byte) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return packs.FailErr(err, "stat", path)
+	}
+	if err := ioutil.WriteFile(path, contents, fi.Mode()); err != nil {
+		return packs.FailErr(err, "write", path)
+	}
+	if err := os.Chmod(path, fi.Mode()); err != nil {
+		return packs.FailErr(err, "chmod", path)
+	}
+	return nil
+}
+
+func readDotEnv(path string) (map[string]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, packs.FailErr(err, "read", path)
+	}
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		name, value, ok, err := parseDotEnvLine(scanner.Text())
+		if err != nil {
+			return nil, packs.FailErr(err, "parse", path+":"+strconv.Itoa(line))
+		}
+		if ok {
+			vars[name] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, packs.FailErr(err, "read", path)
+	}
+	return vars, nil
+}
+
+// parseDotEnvLine parses a line of the form [export] NAME=VALUE, where the
+// value may be single- or double-quoted. Blank lines and comments are
+// skipped.
+func parseDotEnvLine(line string) (name, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+	line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+	kv := strings.SplitN(line, "=", 2)
+	if len(kv) != 2 {
+		return "", "", false, fmt.Errorf("expected NAME=VALUE: %q", line)
+	}
+	name, value = strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if value, err = strconv.Unquote(value); err != nil {
+				return "", "", false, fmt.Errorf("invalid quoted value for %s", name)
+			}
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+	}
+	return name, value, true, nil
+}
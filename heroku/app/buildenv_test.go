@@ -0,0 +1,195 @@
+package app_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	pkgapp "github.com/buildpack/packs/heroku/app"
+)
+
+func testBuildEnv(t *testing.T, when spec.G, it spec.S) {
+	var appDir, envDir string
+
+	it.Before(func() {
+		var err error
+		if appDir, err = ioutil.TempDir("", "pack.buildenv.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if envDir, err = ioutil.TempDir("", "pack.buildenv.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(appDir)
+		os.RemoveAll(envDir)
+	})
+
+	it("should merge app.json, .env and the build env vars", func() {
+		writeFile(t, filepath.Join(appDir, "app.json"), `{
+			"env": {
+				"NODE_ENV": "production",
+				"SOME_VAR": {"description": "some var", "value": "app-json"},
+				"REQUIRED_VAR": {"required": true},
+				"BUILD_TOKEN": {"value": "some-token", "build_only": true}
+			}
+		}`)
+		writeFile(t, filepath.Join(appDir, ".env"), strings.Join([]string{
+			"# some comment",
+			"",
+			"SOME_VAR=dot-env",
+			`export QUOTED_VAR="some \"quoted\" value"`,
+			"SINGLE_VAR='single $value'",
+		}, "\n"))
+		buildEnv, err := pkgapp.ReadBuildEnv(appDir, []string{
+			"PATH=/bin",
+			"PACK_BUILD_ENV_MAVEN_CUSTOM_OPTS=-DskipTests",
+			"PACK_BUILD_SECRET_NPM_TOKEN=some-secret",
+		})
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		expected := map[string]string{
+			"NODE_ENV":          "production",
+			"SOME_VAR":          "dot-env",
+			"BUILD_TOKEN":       "some-token",
+			"QUOTED_VAR":        `some "quoted" value`,
+			"SINGLE_VAR":        "single $value",
+			"MAVEN_CUSTOM_OPTS": "-DskipTests",
+			"NPM_TOKEN":         "some-secret",
+		}
+		if !reflect.DeepEqual(buildEnv.Vars, expected) {
+			t.Fatalf("Mismatched vars:\n%#v\n!=\n%#v\n", buildEnv.Vars, expected)
+		}
+		if expected := map[string]bool{"BUILD_TOKEN": true, "NPM_TOKEN": true}; !reflect.DeepEqual(buildEnv.BuildOnly, expected) {
+			t.Fatalf("Mismatched build-only vars: %#v\n", buildEnv.BuildOnly)
+		}
+	})
+
+	it("should write one file per var and scrub build-only vars after the build", func() {
+		writeFile(t, filepath.Join(appDir, ".env"), "SOME_VAR=some-value\nNPM_TOKEN=some-secret\n")
+		buildEnv, err := pkgapp.ReadBuildEnv(appDir, []string{"PACK_BUILD_SECRET_NPM_TOKEN=other-secret"})
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := buildEnv.WriteDir(envDir); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		for name, value := range map[string]string{"SOME_VAR": "some-value", "NPM_TOKEN": "other-secret"} {
+			if contents, err := ioutil.ReadFile(filepath.Join(envDir, name)); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if string(contents) != value {
+				t.Fatalf("Incorrect value for %s: %s\n", name, contents)
+			}
+		}
+
+		writeFile(t, filepath.Join(appDir, "release.yml"), "addons: []\nconfig_vars:\n  NPM_TOKEN: other-secret\n  LANG: en_US.UTF-8\ndefault_process_types:\n  web: some-command\n")
+		if err := os.Chmod(filepath.Join(appDir, ".env"), 0600); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := buildEnv.Scrub(envDir, appDir, "release.yml"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if _, err := os.Stat(filepath.Join(envDir, "NPM_TOKEN")); !os.IsNotExist(err) {
+			t.Fatal("Build-only var was not removed from env dir")
+		}
+		if _, err := os.Stat(filepath.Join(envDir, "SOME_VAR")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		for name, expected := range map[string]string{
+			".env":        "SOME_VAR=some-value\n",
+			"release.yml": "addons: []\nconfig_vars:\n  LANG: en_US.UTF-8\ndefault_process_types:\n  web: some-command\n",
+		} {
+			if contents, err := ioutil.ReadFile(filepath.Join(appDir, name)); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if string(contents) != expected {
+				t.Fatalf("Incorrect %s:\n%s\n", name, contents)
+			}
+		}
+		if fi, err := os.Stat(filepath.Join(appDir, ".env")); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		} else if fi.Mode().Perm() != 0600 {
+			t.Fatalf("Incorrect .env mode: %s\n", fi.Mode())
+		}
+	})
+
+	it("should scrub the values of build-only vars from app.json", func() {
+		path := filepath.Join(appDir, "app.json")
+		writeFile(t, path, `{
+			"name": "some-app",
+			"env": {
+				"SOME_VAR": {"value": "some-value"},
+				"BUILD_TOKEN": {"description": "some token", "value": "some-token", "build_only": true},
+				"NODE_ENV": "production"
+			}
+		}`)
+		if err := os.Chmod(path, 0600); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		buildEnv, err := pkgapp.ReadBuildEnv(appDir, nil)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := buildEnv.Scrub(envDir, appDir, "release.yml"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if strings.Contains(string(contents), "some-token") {
+			t.Fatalf("Build-only value was not removed from app.json:\n%s\n", contents)
+		}
+		var appJSON map[string]interface{}
+		if err := json.Unmarshal(contents, &appJSON); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		expected := map[string]interface{}{
+			"name": "some-app",
+			"env": map[string]interface{}{
+				"SOME_VAR":    map[string]interface{}{"value": "some-value"},
+				"BUILD_TOKEN": map[string]interface{}{"description": "some token", "build_only": true},
+				"NODE_ENV":    "production",
+			},
+		}
+		if !reflect.DeepEqual(appJSON, expected) {
+			t.Fatalf("Incorrect app.json:\n%s\n", contents)
+		}
+		if fi, err := os.Stat(path); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		} else if fi.Mode().Perm() != 0600 {
+			t.Fatalf("Incorrect app.json mode: %s\n", fi.Mode())
+		}
+	})
+
+	for _, tt := range []struct{ name, file, contents string }{
+		{"an invalid .env line", ".env", "SOME_VAR\n"},
+		{"an invalid var name", ".env", "../SOME_VAR=some-value\n"},
+		{"invalid app.json", "app.json", `{"env": {"SOME_VAR": 1}}`},
+	} {
+		tt := tt
+		when("the app has "+tt.name, func() {
+			it("should return an error", func() {
+				writeFile(t, filepath.Join(appDir, tt.file), tt.contents)
+				if _, err := pkgapp.ReadBuildEnv(appDir, nil); err == nil {
+					t.Fatal("Expected error")
+				}
+			})
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
 
 	"github.com/buildpack/packs"
+	herokuapp "github.com/buildpack/packs/heroku/app"
+	"github.com/buildpack/packs/procfile"
 )
 
 const (
@@ -16,6 +24,11 @@ const (
 	MetadataFile = "release.yml"
 )
 
+var (
+	limits     packs.BuildLimits
+	credential *syscall.Credential
+)
+
 func main() {
 	var buildpacksDir string
 	var buildpackOrder string
@@ -25,6 +38,7 @@ func main() {
 	var envDir string
 	var outputSlug string
 	var outputCache string
+	var detectOnly bool
 	flag.StringVar(&buildpacksDir, "buildpacksDir", "/var/lib/buildpacks", "directory containing buildpacks")
 	flag.StringVar(&buildpackOrder, "buildpackOrder", "heroku/ruby", "list of buildpacks to run")
 	flag.BoolVar(&skipDetect, "skipDetect", false, "run detection")
@@ -33,29 +47,76 @@ func main() {
 	flag.StringVar(&envDir, "envDir", "/tmp/env", "directory containing the env vars")
 	flag.StringVar(&outputSlug, "outputSlug", "/out/slug.tgz", "output file containing the slug")
 	flag.StringVar(&outputCache, "outputCache", "/cache/cache.tgz", "output file containing the cache")
+	flag.BoolVar(&detectOnly, "detect-only", false, "report which buildpacks detect the app without building it")
+	if err := packs.InputBuildLimits(flag.CommandLine, &limits, "detect", "compile", "release", "make-slug", "cache"); err != nil {
+		fatal(err, packs.CodeInvalidArgs, "parse arguments")
+	}
 
 	flag.Parse()
 
-	os.MkdirAll(appDir, os.ModeTemporary)
-	os.MkdirAll(cacheDir, os.ModeTemporary)
-	os.MkdirAll(envDir, os.ModeTemporary)
 	os.MkdirAll(buildpacksDir, os.ModePerm)
 	os.MkdirAll(filepath.Dir(outputSlug), os.ModePerm)
 	os.MkdirAll(filepath.Dir(outputCache), os.ModePerm)
 
+	uid, gid, err := packs.LookupUser("heroku")
+	if err != nil {
+		fatal(err, packs.CodeFailed, "determine heroku UID/GID")
+	}
+	credential = &syscall.Credential{Uid: uid, Gid: gid}
+	buildEnv, err := herokuapp.ReadBuildEnv(appDir, os.Environ())
+	if err != nil {
+		fatal(err, packs.CodeInvalidEnv, "read config vars")
+	}
+	if err := buildEnv.WriteDir(envDir); err != nil {
+		fatal(err, packs.CodeInvalidEnv, "write config vars to", envDir)
+	}
+	if err := herokuDirAll(appDir, cacheDir, envDir); err != nil {
+		fatal(err, packs.CodeFailed, "prepare source directories")
+	}
+	if err := packs.ChownStdFds("heroku"); err != nil {
+		fatal(err, packs.CodeFailed, "adjust fd ownership")
+	}
+
 	buildpacks := strings.Split(buildpackOrder, ",")
+	if detectOnly {
+		if !flagSet("buildpackOrder") {
+			buildpacks = nil
+		}
+		results, err := detectAll(appDir, buildpacksDir, buildpacks)
+		if err != nil {
+			fatal(err, packs.CodeFailed, "find buildpacks")
+		}
+		out, err := json.Marshal(results)
+		if err != nil {
+			fatal(err, packs.CodeFailed, "encode detection results")
+		}
+		fmt.Println(string(out))
+		if !packs.Detected(results) {
+			os.Exit(packs.CodeFailedDetect)
+		}
+		return
+	}
+
 	if strings.Join(buildpacks, "") == "" && !skipDetect {
 		buildpack, err := detect(appDir, buildpacksDir)
 		if err != nil || buildpack == "" {
-			fatal(err, packs.CodeFailed, "detect")
+			fatal(err, packs.CodeFailedDetect, "detect")
 		}
 
 		buildpacks = []string{buildpack}
 	}
 
+	processTypes, err := procfile.Read(filepath.Join(appDir, "Procfile"))
+	if err != nil {
+		fatal(err, packs.CodeFailedBuild, "parse Procfile")
+	}
+	if len(processTypes) > 0 {
+		fmt.Printf("Procfile declares types -> %s\n", strings.Join(procfile.Types(processTypes), ", "))
+	}
+
 	buildpackOptions := createBuildpackOptions(buildpacks)
 
-	err := compile(appDir, cacheDir, envDir, buildpacksDir, buildpackOptions)
+	err = compile(appDir, cacheDir, envDir, buildpacksDir, buildpackOptions)
 	if err != nil {
 		fatal(err, packs.CodeFailedBuild, "compile")
 	}
@@ -65,6 +126,16 @@ func main() {
 		fatal(err, packs.CodeFailedBuild, "release")
 	}
 
+	err = buildEnv.Scrub(envDir, appDir, MetadataFile)
+	if err != nil {
+		fatal(err, packs.CodeFailedBuild, "remove build-only config vars")
+	}
+
+	err = recordProcessTypes(filepath.Join(appDir, MetadataFile), processTypes)
+	if err != nil {
+		fatal(err, packs.CodeFailedBuild, "record process types")
+	}
+
 	err = makeSlug("/tmp/slug.tgz", appDir)
 	if err != nil {
 		fatal(err, packs.CodeFailedBuild, "make-slug")
@@ -81,11 +152,65 @@ func main() {
 	}
 }
 
+// recordProcessTypes sets process_types in the release metadata at path to
+// the default process types of the buildpacks, overridden by those
+// declared in the Procfile.
+func recordProcessTypes(path string, procfileTypes map[string]string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return packs.FailErr(err, "stat", path)
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return packs.FailErr(err, "read", path)
+	}
+	var info struct {
+		DefaultProcessTypes map[string]string `yaml:"default_process_types"`
+	}
+	if err := yaml.Unmarshal(contents, &info); err != nil {
+		return packs.FailErr(err, "parse", path)
+	}
+	processTypes := map[string]string{}
+	for name, command := range info.DefaultProcessTypes {
+		processTypes[name] = command
+	}
+	for name, command := range procfileTypes {
+		processTypes[name] = command
+	}
+
+	var release yaml.MapSlice
+	if err := yaml.Unmarshal(contents, &release); err != nil {
+		return packs.FailErr(err, "parse", path)
+	}
+	item := yaml.MapItem{Key: "process_types", Value: processTypes}
+	found := false
+	for i := range release {
+		if release[i].Key == item.Key {
+			release[i], found = item, true
+		}
+	}
+	if !found {
+		release = append(release, item)
+	}
+	out, err := yaml.Marshal(release)
+	if err != nil {
+		return packs.FailErr(err, "encode", path)
+	}
+	if err := ioutil.WriteFile(path, out, fi.Mode()); err != nil {
+		return packs.FailErr(err, "write", path)
+	}
+	return nil
+}
+
 func detect(appDir, buildpackDir string) (string, error) {
+	out := &bytes.Buffer{}
 	cmd := exec.Command(Cytokine, "detect-buildpack", "--verbose", appDir, buildpackDir)
-	out, err := cmd.CombinedOutput()
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	err := limits.Run(cmd, "detect")
 	if err == nil {
-		properties := strings.Split(string(out), " ")
+		properties := strings.Split(out.String(), " ")
 		for _, property := range properties {
 			if strings.LastIndex(property, "buildpack=") == 0 {
 				rawBuildpackString := strings.Replace(property, "buildpack=", "", 1)
@@ -98,6 +223,74 @@ func detect(appDir, buildpackDir string) (string, error) {
 	}
 }
 
+func detectAll(appDir, buildpacksDir string, names []string) ([]packs.DetectResult, error) {
+	if len(names) == 0 {
+		var err error
+		if names, err = installedBuildpacks(buildpacksDir); err != nil {
+			return nil, err
+		}
+	}
+	var buildpacks []packs.Buildpack
+	for _, name := range names {
+		buildpacks = append(buildpacks, packs.Buildpack{Name: name, Dir: filepath.Join(buildpacksDir, name)})
+	}
+	return packs.Detect(appDir, buildpacks, func(cmd *exec.Cmd) error {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+		return limits.Run(cmd, "detect")
+	}), nil
+}
+
+// installedBuildpacks returns the buildpacks in buildpacksDir in the
+// priority order listed in its config.json, or every buildpack in the
+// directory if there is no config.
+func installedBuildpacks(buildpacksDir string) ([]string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(buildpacksDir, "config.json"))
+	if os.IsNotExist(err) {
+		return globBuildpacks(buildpacksDir)
+	} else if err != nil {
+		return nil, err
+	}
+	var config []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, bp := range config {
+		names = append(names, bp.Name)
+	}
+	return names, nil
+}
+
+func globBuildpacks(buildpacksDir string) ([]string, error) {
+	var names []string
+	for _, pattern := range []string{"*/bin/detect", "*/*/bin/detect"} {
+		matches, err := filepath.Glob(filepath.Join(buildpacksDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			name, err := filepath.Rel(buildpacksDir, filepath.Dir(filepath.Dir(match)))
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func compile(appDir, cacheDir, envDir, buildpackDir string, buildpacks []string) error {
 
 	args := append([]string{"run-buildpacks", appDir, cacheDir, envDir, buildpackDir}, buildpacks...)
@@ -105,21 +298,15 @@ func compile(appDir, cacheDir, envDir, buildpackDir string, buildpacks []string)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-
-	// TODO don't run the buildpack as root
-	//uid, gid := userLookup("heroku")
-	//cmd.SysProcAttr = &syscall.SysProcAttr{
-	//	Credential: &syscall.Credential{Uid: uid, Gid: gid},
-	//}
-
-	err := cmd.Run()
-	return err
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	return limits.Run(cmd, "compile")
 }
 
 func release(appDir, buildpackDir, metadataFile string, buildpacks []string) error {
 	args := append([]string{"release-buildpacks", appDir, buildpackDir, metadataFile}, buildpacks...)
-	err := exec.Command(Cytokine, args...).Run()
-	return err
+	cmd := exec.Command(Cytokine, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	return limits.Run(cmd, "release")
 }
 
 func makeSlug(outputSlug, appDir string) error {
@@ -127,8 +314,7 @@ func makeSlug(outputSlug, appDir string) error {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	err := cmd.Run()
-	return err
+	return limits.Run(cmd, "make-slug")
 }
 
 func createBuildpackOptions(buildpacks []string) []string {
@@ -141,10 +327,25 @@ func createBuildpackOptions(buildpacks []string) []string {
 
 func compress(src, tgz string) error {
 	// TODO capture error messages and log them in debug mode
-	return exec.Command("tar", "-C", src, "-czf", tgz, ".").Run()
+	return limits.Run(exec.Command("tar", "-C", src, "-czf", tgz, "."), "cache")
+}
+
+func herokuDirAll(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return packs.FailErr(err, "make directory", dir)
+		}
+		if _, err := packs.Run("chown", "-R", "heroku:heroku", dir); err != nil {
+			return packs.FailErr(err, "recursively chown", dir, "to", "heroku:heroku")
+		}
+	}
+	return nil
 }
 
 func fatal(err error, code int, action ...string) {
+	if err, ok := err.(*packs.ErrorFail); ok && err.Code == packs.CodeTimeout {
+		code = err.Code
+	}
 	message := "failed to " + strings.Join(action, " ")
 	fmt.Fprintf(os.Stderr, "Error: %s: %s", message, err)
 	os.Exit(code)
@@ -6,30 +6,31 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/buildpack/packs"
 	herokuapp "github.com/buildpack/packs/heroku/app"
+	"github.com/buildpack/packs/launcher"
+	"github.com/buildpack/packs/procfile"
 )
 
-type ErrProcfileNoProcess string
-
-func (e ErrProcfileNoProcess) Error() string {
-	return fmt.Sprintf("%s", e)
-}
-
-type ErrNoCommandFound string
-
-func (e ErrNoCommandFound) Error() string {
-	return fmt.Sprintf("%s", e)
-}
-
 func main() {
-	var inputDroplet string
+	var (
+		inputDroplet, formation string
+		useInit                 bool
+		gracePeriod             time.Duration
+		release, runRelease     bool
+	)
 	flag.StringVar(&inputDroplet, "inputDroplet", "/tmp/droplet", "file containing compressed droplet")
+	packs.InputProcesses(&formation)
+	if err := packs.InputInit(&useInit, &gracePeriod); err != nil {
+		check(err, packs.CodeInvalidArgs, "parse arguments")
+	}
+	packs.InputRelease(&release, &runRelease)
 	flag.Parse()
 	command := strings.Join(flag.Args(), " ")
 
@@ -41,10 +42,12 @@ func main() {
 	err := os.Chdir("/app")
 	check(err, packs.CodeFailed, "change directory")
 
-	if command == "" {
-		processType := getProcessType()
-		command, err = readCommand(processType)
-		check(err, packs.CodeFailed, "please add a Procfile with a web process")
+	processType, fromProcfile := getProcessType(), command == ""
+	if command == "" && formation == "" && !release {
+		processTypes, err := readProcessTypes()
+		check(err, packs.CodeInvalidArgs, "read process types")
+		command, err = procfile.Lookup(processTypes, processType)
+		check(err, packs.CodeInvalidArgs, "find start command, please add it to your Procfile")
 	}
 
 	app, err := herokuapp.New()
@@ -54,73 +57,143 @@ func main() {
 		check(err, packs.CodeInvalidEnv, "set app env")
 	}
 
-	args := []string{"/lifecycle/launcher", "/app", command, ""}
-	err = syscall.Exec("/lifecycle/launcher", args, os.Environ())
-	check(err, packs.CodeFailedLaunch, "launch")
-}
-
-func getProcessType() string {
-	if value, ok := os.LookupEnv("DYNO"); ok {
-		return strings.Split(value, ".")[0]
+	if release {
+		os.Exit(runReleasePhase(gracePeriod))
+	}
+	// only run release before web processes, so that one-off commands and
+	// other process types start immediately
+	if runRelease && fromProcfile && (formation == "" && processType == "web" || hasType(formation, "web")) {
+		if code := runReleasePhase(gracePeriod); code != 0 {
+			os.Exit(code)
+		}
 	}
-	return "web"
-}
 
-func supplyApp(tgz, dst string) {
-	if _, err := os.Stat(tgz); os.IsNotExist(err) {
+	if command == "" && formation != "" {
+		supervise(formation, gracePeriod)
 		return
-	} else {
-		check(err, packs.CodeFailed, "stat", tgz)
 	}
-	err := exec.Command("tar", "-C", dst, "-xzf", tgz).Run()
-	check(err, packs.CodeFailed, "untar", tgz, "to", dst)
+	if useInit {
+		runInit(command, gracePeriod)
+		return
+	}
+
+	err = launcher.Launch("/app", command, os.Environ())
+	check(err, packs.CodeFailedLaunch, "launch")
 }
 
-func readCommand(processType string) (string, error) {
-	if command, err := parseProcfile("/app/Procfile", processType); err == nil {
-		return command, nil
-	} else if command, err = parseReleaseYml("/app/release.yml", processType); err == nil {
-		return command, nil
+func supervise(formation string, gracePeriod time.Duration) {
+	basePort, err := strconv.Atoi(os.Getenv("PORT"))
+	if err != nil {
+		basePort = 5000
+	}
+	processTypes, err := readProcessTypes()
+	check(err, packs.CodeInvalidArgs, "read process types")
+	procs, err := launcher.Processes(formation, processTypes, basePort)
+	check(err, packs.CodeInvalidArgs, "parse processes")
+	for i, p := range procs {
+		procs[i].Env = append(p.Env, "DYNO="+p.Name())
+	}
+	fi, err := os.Stdout.Stat()
+	supervisor := &launcher.Supervisor{
+		AppDir:      "/app",
+		Env:         os.Environ(),
+		Out:         os.Stdout,
+		Color:       err == nil && fi.Mode()&os.ModeCharDevice != 0,
+		GracePeriod: gracePeriod,
 	}
-	return "", ErrNoCommandFound("No command found, please specify one in your Procfile.")
+	err = supervisor.Run(procs)
+	if exitErr, ok := err.(*launcher.ExitError); ok {
+		check(exitErr, exitErr.Code, "run", exitErr.Name)
+	}
+	check(err, packs.CodeFailedLaunch, "run processes")
 }
 
-func parseProcfile(path, processType string) (string, error) {
-	if _, err := os.Stat(path); err == nil {
-		buf, err := ioutil.ReadFile(path)
-		procfile := string(buf)
-		check(err, packs.CodeFailed, "parse Procfile")
+func runInit(command string, gracePeriod time.Duration) {
+	cmd, err := launcher.Cmd("/app", command, os.Environ())
+	check(err, packs.CodeFailedLaunch, "launch")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	code, err := (&launcher.Init{Out: os.Stderr, GracePeriod: gracePeriod}).Run(cmd)
+	check(err, packs.CodeFailedLaunch, "launch")
+	os.Exit(code)
+}
 
-		processes := make(map[string]string)
+// runReleasePhase runs the release process, if declared, to completion and
+// returns its exit code.
+func runReleasePhase(gracePeriod time.Duration) int {
+	processTypes, err := readProcessTypes()
+	check(err, packs.CodeInvalidArgs, "read process types")
+	command, ok := processTypes["release"]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "No release process declared, skipping release phase")
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Running release command: %s\n", command)
+	cmd, err := launcher.Cmd("/app", command, append(os.Environ(), "DYNO=release.1"))
+	check(err, packs.CodeFailedLaunch, "run release phase")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	code, err := (&launcher.Init{Out: os.Stderr, GracePeriod: gracePeriod}).Run(cmd)
+	check(err, packs.CodeFailedLaunch, "run release phase")
+	if code != 0 {
+		fmt.Fprintf(os.Stderr, "Release phase failed with exit code %d\n", code)
+	}
+	return code
+}
 
-		for _, line := range strings.Split(procfile, "\n") {
-			array := strings.SplitAfterN(line, ":", 2)
-			if len(array) == 2 {
-				processes[array[0]] = strings.TrimSpace(array[1])
-			}
+// hasType returns true if formation includes processType.
+func hasType(formation, processType string) bool {
+	for _, entry := range strings.Split(formation, ",") {
+		if strings.TrimSpace(strings.SplitN(entry, "=", 2)[0]) == processType {
+			return true
 		}
+	}
+	return false
+}
 
-		if process, ok := processes[fmt.Sprintf("%s:", processType)]; ok {
-			return process, nil
+// readProcessTypes returns the process types recorded in release.yml by the
+// builder. For slugs built without them, it returns the default process
+// types from release.yml, overridden by the Procfile.
+func readProcessTypes() (map[string]string, error) {
+	processTypes := map[string]string{}
+	if releaseYml, err := ioutil.ReadFile("/app/release.yml"); err == nil {
+		var info struct {
+			ProcessTypes        map[string]string `yaml:"process_types"`
+			DefaultProcessTypes map[string]string `yaml:"default_process_types"`
+		}
+		if err := yaml.Unmarshal(releaseYml, &info); err != nil {
+			return nil, packs.FailErr(err, "parse release.yml")
+		}
+		if info.ProcessTypes != nil {
+			return info.ProcessTypes, nil
+		}
+		for name, command := range info.DefaultProcessTypes {
+			processTypes[name] = command
 		}
 	}
-
-	return "", ErrProcfileNoProcess("No web process in Procfile.")
+	procfileTypes, err := procfile.Read("/app/Procfile")
+	if err != nil {
+		return nil, packs.FailErr(err, "parse Procfile")
+	}
+	for name, command := range procfileTypes {
+		processTypes[name] = command
+	}
+	return processTypes, nil
 }
 
-func parseReleaseYml(path, processType string) (string, error) {
-	releaseYml, err := ioutil.ReadFile(path)
-	check(err, packs.CodeFailed, "read start command")
-	var info struct {
-		Addons              []string          `yaml:"addons"`
-		DefaultProcessTypes map[string]string `yaml:"default_process_types"`
+func getProcessType() string {
+	if value, ok := os.LookupEnv("DYNO"); ok {
+		return strings.Split(value, ".")[0]
 	}
-	err = yaml.Unmarshal(releaseYml, &info)
-	if err == nil {
-		return info.DefaultProcessTypes[processType], nil
+	return "web"
+}
+
+func supplyApp(tgz, dst string) {
+	if _, err := os.Stat(tgz); os.IsNotExist(err) {
+		return
 	} else {
-		return "", err
+		check(err, packs.CodeFailed, "stat", tgz)
 	}
+	err := exec.Command("tar", "-C", dst, "-xzf", tgz).Run()
+	check(err, packs.CodeFailed, "untar", tgz, "to", dst)
 }
 
 func chownAll(user, group, path string) error {
@@ -2,33 +2,13 @@ package main
 
 import (
 	"fmt"
-	"github.com/buildpack/packs"
-	herokuapp "github.com/buildpack/packs/heroku/app"
 	"os"
-	"runtime"
 	"strings"
-	"syscall"
-)
-
-const shellScript = `
-cd $1
-
-if [ -n "$(ls ../profile.d/* 2> /dev/null)" ]; then
-  for env_file in ../profile.d/*; do
-    source $env_file
-  done
-fi
-
-if [ -n "$(ls .profile.d/* 2> /dev/null)" ]; then
-  for env_file in .profile.d/*; do
-    source $env_file
-  done
-fi
 
-shift
-
-exec bash
-`
+	"github.com/buildpack/packs"
+	herokuapp "github.com/buildpack/packs/heroku/app"
+	"github.com/buildpack/packs/launcher"
+)
 
 func main() {
 	err := os.Chdir("/app")
@@ -41,12 +21,7 @@ func main() {
 		check(err, packs.CodeInvalidEnv, "set app env")
 	}
 
-	runtime.GOMAXPROCS(1)
-
-	err = syscall.Exec("/bin/bash", []string{
-		"bash", "-c", shellScript, "bash", "/app",
-	}, os.Environ())
-
+	err = launcher.Shell("/app", "", os.Environ())
 	check(err, packs.CodeFailedLaunch, "run")
 }
 
@@ -3,32 +3,73 @@ package packs
 import (
 	"flag"
 	"os"
+	"strings"
+	"time"
 )
 
 const (
 	EnvAppDir = "PACK_APP_DIR"
 	EnvAppZip = "PACK_APP_ZIP"
 
-	EnvAppName = "PACK_APP_NAME"
-	EnvAppURI  = "PACK_APP_URI"
+	EnvAppName       = "PACK_APP_NAME"
+	EnvAppURI        = "PACK_APP_URI"
+	EnvAppConfig     = "PACK_APP_CONFIG"
+	EnvAppGUID       = "PACK_APP_GUID"
+	EnvAppVersion    = "PACK_APP_VERSION"
+	EnvSpaceName     = "PACK_SPACE_NAME"
+	EnvSpaceGUID     = "PACK_SPACE_GUID"
+	EnvOrgName       = "PACK_ORG_NAME"
+	EnvOrgGUID       = "PACK_ORG_GUID"
+	EnvInstanceIndex = "PACK_INSTANCE_INDEX"
 
 	EnvAppDisk   = "PACK_APP_DISK"
 	EnvAppMemory = "PACK_APP_MEM"
 	EnvAppFds    = "PACK_APP_FDS"
+	EnvAppCPU    = "PACK_APP_CPU"
+	EnvAppIP     = "PACK_APP_IP"
+	EnvAppPort   = "PACK_APP_PORT"
+	EnvAppPorts  = "PACK_APP_PORTS"
+
+	EnvServicesDir  = "PACK_SERVICES_DIR"
+	EnvServicesFile = "PACK_SERVICES_FILE"
+	EnvCredentials  = "PACK_CREDENTIALS"
+	EnvCredhubToken = "PACK_CREDHUB_TOKEN"
+	EnvStagingEnv   = "PACK_STAGING_ENV"
+	EnvRunningEnv   = "PACK_RUNNING_ENV"
 
 	EnvDropletPath  = "PACK_DROPLET_PATH"
+	EnvDropletAuth  = "PACK_DROPLET_AUTH"
 	EnvSlugPath     = "PACK_SLUG_PATH"
 	EnvMetadataPath = "PACK_METADATA_PATH"
 
+	EnvCacheImage = "PACK_CACHE_IMAGE"
+
+	EnvProcessType = "PACK_PROCESS_TYPE"
+	EnvProcesses   = "PACK_PROCESSES"
+	EnvInit        = "PACK_INIT"
+	EnvGracePeriod = "PACK_GRACE_PERIOD"
+	EnvRunRelease  = "PACK_RUN_RELEASE"
+
 	EnvStackName  = "PACK_STACK_NAME"
+	EnvStackID    = "PACK_STACK_ID"
 	EnvUseDaemon  = "PACK_USE_DAEMON"
 	EnvUseHelpers = "PACK_USE_HELPERS"
+
+	EnvBuildTimeout   = "PACK_BUILD_TIMEOUT"
+	EnvPhaseTimeouts  = "PACK_PHASE_TIMEOUTS"
+	EnvRlimitCPU      = "PACK_RLIMIT_CPU"
+	EnvRlimitProcs    = "PACK_RLIMIT_NPROC"
+	EnvRlimitFileSize = "PACK_RLIMIT_FSIZE"
 )
 
 func InputDropletPath(path *string) {
 	flag.StringVar(path, "droplet", os.Getenv(EnvDropletPath), "file containing droplet")
 }
 
+func InputDropletLocation(location *string) {
+	flag.StringVar(location, "droplet", os.Getenv(EnvDropletPath), "file, http(s) URL or image reference containing droplet")
+}
+
 func InputSlugPath(path *string) {
 	flag.StringVar(path, "slug", os.Getenv(EnvSlugPath), "file containing slug")
 }
@@ -37,6 +78,45 @@ func InputMetadataPath(path *string) {
 	flag.StringVar(path, "metadata", os.Getenv(EnvMetadataPath), "file containing artifact metadata")
 }
 
+func InputServicesFile(path *string) {
+	flag.StringVar(path, "services", os.Getenv(EnvServicesFile), "YAML file describing bound services")
+}
+
+func InputStagingEnv(flags *flag.FlagSet, path *string) {
+	flags.StringVar(path, "stagingEnv", os.Getenv(EnvStagingEnv), "JSON or YAML file containing the staging env var group")
+}
+
+func InputRunningEnv(path *string) {
+	flag.StringVar(path, "runningEnv", os.Getenv(EnvRunningEnv), "JSON or YAML file containing the running env var group")
+}
+
+func InputCredentials(location *string) {
+	flag.StringVar(location, "credentials", os.Getenv(EnvCredentials), "CredHub URL, directory or file used to resolve credhub-ref credentials")
+}
+
+func InputProcessType(processType *string) {
+	name := os.Getenv(EnvProcessType)
+	if name == "" {
+		name = "web"
+	}
+	flag.StringVar(processType, "process", name, "process type to launch")
+}
+
+func InputProcesses(formation *string) {
+	flag.StringVar(formation, "processes", os.Getenv(EnvProcesses), "comma-separated list of process types to supervise, with optional counts (e.g. web,worker=2)")
+}
+
+func InputInit(use *bool, grace *time.Duration) error {
+	flag.BoolVar(use, "init", boolEnv(EnvInit), "run as a minimal init that reaps zombies and forwards signals to the app")
+	flag.DurationVar(grace, "gracePeriod", 0, "time to wait after forwarding a signal before killing the app")
+	return setFromEnv(flag.CommandLine, "gracePeriod", EnvGracePeriod)
+}
+
+func InputRelease(release, runRelease *bool) {
+	flag.BoolVar(release, "release", false, "run the release process to completion and exit with its exit code")
+	flag.BoolVar(runRelease, "runRelease", boolEnv(EnvRunRelease), "run the release process before starting the web process")
+}
+
 func InputStackName(image *string) {
 	flag.StringVar(image, "stack", os.Getenv(EnvStackName), "image repository containing stack image")
 }
@@ -49,7 +129,54 @@ func InputUseHelpers(use *bool) {
 	flag.BoolVar(use, "helpers", boolEnv(EnvUseHelpers), "use credential helpers")
 }
 
+func InputAppPorts(port *uint, ports *Ports) error {
+	flag.Var((*portValue)(port), "port", "port the app listens on")
+	flag.Var(&portsValue{ports: ports}, "ports", "comma-separated list of additional ports the app listens on")
+	if err := setFromEnv(flag.CommandLine, "port", EnvAppPort); err != nil {
+		return err
+	}
+	var err error
+	if *ports, err = ParsePorts(os.Getenv(EnvAppPorts)); err != nil {
+		return FailErrCode(err, CodeInvalidArgs, "parse", EnvAppPorts)
+	}
+	return nil
+}
+
+func InputBuildLimits(flags *flag.FlagSet, limits *BuildLimits, phases ...string) error {
+	limits.PhaseTimeouts = PhaseTimeouts{}
+	flags.DurationVar(&limits.Timeout, "timeout", 0, "maximum duration of the whole build")
+	flags.Var(&phaseTimeoutsValue{limits.PhaseTimeouts, phases}, "phaseTimeouts",
+		"comma-separated list of phase=duration timeouts for phases: "+strings.Join(phases, ", "))
+	flags.Uint64Var(&limits.CPUSeconds, "rlimitCPU", 0, "maximum CPU seconds for each buildpack process")
+	flags.Uint64Var(&limits.MaxProcs, "rlimitNproc", 0, "maximum number of processes for the build user")
+	flags.Uint64Var(&limits.MaxFileSize, "rlimitFsize", 0, "maximum size in bytes of files written by buildpacks")
+	return setFromEnv(flags,
+		"timeout", EnvBuildTimeout,
+		"phaseTimeouts", EnvPhaseTimeouts,
+		"rlimitCPU", EnvRlimitCPU,
+		"rlimitNproc", EnvRlimitProcs,
+		"rlimitFsize", EnvRlimitFileSize,
+	)
+}
+
 func boolEnv(k string) bool {
 	v := os.Getenv(k)
 	return v == "true" || v == "1"
 }
+
+// setFromEnv sets each named flag to the value of the env var that follows
+// it, if that env var is set, so that invalid values are reported instead
+// of ignored.
+func setFromEnv(flags *flag.FlagSet, pairs ...string) error {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		name, k := pairs[i], pairs[i+1]
+		v := os.Getenv(k)
+		if v == "" {
+			continue
+		}
+		if err := flags.Set(name, v); err != nil {
+			return FailErrCode(err, CodeInvalidArgs, "parse", k)
+		}
+	}
+	return nil
+}
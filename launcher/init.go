@@ -0,0 +1,113 @@
+package launcher
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/buildpack/packs"
+)
+
+// not exported by syscall on linux
+const prSetChildSubreaper = 36
+
+// Init runs a process as a minimal init: it reaps orphaned processes and
+// forwards termination signals to the process group of the app.
+type Init struct {
+	Out         io.Writer
+	GracePeriod time.Duration
+}
+
+// Run starts cmd in its own process group and waits for it to exit while
+// reaping any other children. SIGTERM, SIGINT and SIGQUIT are forwarded to
+// the process group, which is killed if it is still running after the
+// grace period. If the stdin of cmd is a terminal, cmd stays in the
+// foreground process group of the terminal so that it can read from it.
+// Signals are then forwarded to cmd alone, except SIGINT and SIGQUIT,
+// which the terminal already sends to cmd. The stdio of cmd must be nil
+// or *os.File, since the process is waited for directly. Run returns the
+// exit code of cmd, or 128 plus the signal number if cmd was terminated by
+// a signal.
+func (i *Init) Run(cmd *exec.Cmd) (int, error) {
+	out := i.Out
+	if out == nil {
+		out = os.Stderr
+	}
+	grace := i.GracePeriod
+	if grace == 0 {
+		grace = defaultGracePeriod
+	}
+	if os.Getpid() != 1 {
+		// adopt orphaned descendants so that they can be reaped
+		syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	}
+
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGCHLD)
+	defer signal.Stop(signals)
+
+	stdin, ok := cmd.Stdin.(*os.File)
+	interactive := ok && packs.IsTerminal(stdin)
+	if !interactive {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	target, targetName := -pid, fmt.Sprintf("process group %d", pid)
+	if interactive {
+		target, targetName = pid, fmt.Sprintf("process %d", pid)
+	}
+
+	var kill <-chan time.Time
+	for {
+		if status, exited := reap(pid); exited {
+			if status.Signaled() {
+				fmt.Fprintf(out, "process %d terminated by signal %s\n", pid, status.Signal())
+				return 128 + int(status.Signal()), nil
+			}
+			fmt.Fprintf(out, "process %d exited with code %d\n", pid, status.ExitStatus())
+			return status.ExitStatus(), nil
+		}
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGCHLD || interactive && sig != syscall.SIGTERM {
+				continue
+			}
+			fmt.Fprintf(out, "forwarding %s to %s\n", sig, targetName)
+			syscall.Kill(target, sig.(syscall.Signal))
+			if kill == nil {
+				kill = time.After(grace)
+			}
+		case <-kill:
+			fmt.Fprintf(out, "killing %s after %s\n", targetName, grace)
+			syscall.Kill(target, syscall.SIGKILL)
+		}
+	}
+}
+
+// reap waits for every child that has exited and reports whether pid was
+// one of them.
+func reap(pid int) (status syscall.WaitStatus, exited bool) {
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || wpid <= 0 {
+			return status, exited
+		}
+		if wpid == pid {
+			status, exited = ws, true
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package launcher_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/launcher"
+)
+
+func testInit(t *testing.T, when spec.G, it spec.S) {
+	var (
+		appDir string
+		out    *bytes.Buffer
+		i      *launcher.Init
+	)
+
+	it.Before(func() {
+		var err error
+		if appDir, err = ioutil.TempDir("", "pack.init.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		out = &bytes.Buffer{}
+		i = &launcher.Init{Out: out, GracePeriod: 500 * time.Millisecond}
+	})
+
+	it.After(func() {
+		os.RemoveAll(appDir)
+	})
+
+	run := func(command string) int {
+		t.Helper()
+		cmd, err := launcher.Cmd(appDir, command, nil)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		code, err := i.Run(cmd)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		return code
+	}
+
+	it("should return and report the exit code of the command", func() {
+		if code := run("exit 3"); code != 3 {
+			t.Fatalf("Incorrect exit code: %d\n", code)
+		}
+		if !strings.Contains(out.String(), "exited with code 3\n") {
+			t.Fatalf("Missing exit code in:\n%s\n", out)
+		}
+	})
+
+	it("should reap orphaned processes", func() {
+		pidFile := filepath.Join(appDir, "orphan.pid")
+		if code := run("(sleep 0.2 & echo $! > " + pidFile + ")"); code != 0 {
+			t.Fatalf("Incorrect exit code: %d\n", code)
+		}
+		// the orphan outlives the command, so reap it with a second command
+		time.Sleep(500 * time.Millisecond)
+		run("true")
+		pid, err := ioutil.ReadFile(pidFile)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", strings.TrimSpace(string(pid)), "stat"))
+		if err == nil && strings.Contains(string(stat), ") Z ") {
+			t.Fatalf("Orphan was not reaped: %s\n", stat)
+		}
+	})
+
+	// isLeader exits 0 if the shell leads its own process group
+	const isLeader = `read -a stat < /proc/$$/stat; [ "${stat[4]}" = "$$" ]`
+
+	it("should run the command in its own process group", func() {
+		if code := run(isLeader); code != 0 {
+			t.Fatalf("Command is not a process group leader: %d\n", code)
+		}
+	})
+
+	when("stdin is a terminal", func() {
+		it("should keep the command in the foreground process group", func() {
+			pty, tty := openPTY(t)
+			defer pty.Close()
+			defer tty.Close()
+			cmd, err := launcher.Cmd(appDir, isLeader, nil)
+			if err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			cmd.Stdin = tty
+			if code, err := i.Run(cmd); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			} else if code == 0 {
+				t.Fatal("Command was moved to a new process group")
+			}
+		})
+	})
+
+	it("should forward signals and kill the process group after the grace period", func() {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			syscall.Kill(os.Getpid(), syscall.SIGTERM)
+		}()
+		start := time.Now()
+		if code := run(`trap "echo ignored" TERM; while true; do sleep 0.1; done`); code != 128+int(syscall.SIGKILL) {
+			t.Fatalf("Incorrect exit code: %d\n", code)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Process was not killed:\n%s\n", out)
+		}
+		for _, line := range []string{
+			"forwarding terminated to process group",
+			"killing process group",
+			"terminated by signal killed\n",
+		} {
+			if !strings.Contains(out.String(), line) {
+				t.Fatalf("Missing output %q in:\n%s\n", line, out)
+			}
+		}
+	})
+}
+
+// openPTY opens both sides of a new pseudo-terminal.
+func openPTY(t *testing.T) (pty, tty *os.File) {
+	t.Helper()
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("Cannot open pseudo-terminal: %s\n", err)
+	}
+	var n, unlock uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		ptmx.Close()
+		t.Skipf("Cannot unlock pseudo-terminal: %s\n", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		ptmx.Close()
+		t.Skipf("Cannot get pseudo-terminal: %s\n", errno)
+	}
+	tty, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptmx.Close()
+		t.Skipf("Cannot open pseudo-terminal: %s\n", err)
+	}
+	return ptmx, tty
+}
@@ -0,0 +1,189 @@
+package launcher
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const bash = "/bin/bash"
+
+const sourceProfileD = `
+cd "$1"
+
+if [ -n "$(ls ../profile.d/* 2> /dev/null)" ]; then
+  for env_file in ../profile.d/*; do
+    source $env_file
+  done
+fi
+
+if [ -n "$(ls .profile.d/* 2> /dev/null)" ]; then
+  for env_file in .profile.d/*; do
+    source $env_file
+  done
+fi
+`
+
+const sourceProfile = `
+if [ -f .profile ]; then
+  source .profile
+fi
+`
+
+const execCommand = `
+shift
+
+exec bash -c "$@"
+`
+
+var databaseSchemes = map[string]string{
+	"mysql":      "mysql2",
+	"mysql2":     "mysql2",
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+}
+
+// Launch replaces the current process with command, run in appDir after
+// sourcing the profile.d scripts of the droplet and app and the app's
+// .profile, using env adjusted by Env.
+func Launch(appDir, command string, env []string) error {
+	cmd, err := Cmd(appDir, command, env)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(cmd.Path, cmd.Args, cmd.Env)
+}
+
+// Shell replaces the current process with command, or bash if command is
+// empty, run in appDir after sourcing only the profile.d scripts.
+func Shell(appDir, command string, env []string) error {
+	if command == "" {
+		command = "bash"
+	}
+	cmd, err := cmd(appDir, command, env, false)
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(cmd.Path, cmd.Args, cmd.Env)
+}
+
+// Cmd returns a command that runs command in the same way as Launch.
+func Cmd(appDir, command string, env []string) (*exec.Cmd, error) {
+	return cmd(appDir, command, env, true)
+}
+
+func cmd(appDir, command string, env []string, profile bool) (*exec.Cmd, error) {
+	if command == "" {
+		return nil, errors.New("no start command specified or detected in droplet")
+	}
+	appDir, err := filepath.Abs(appDir)
+	if err != nil {
+		return nil, err
+	}
+	script := sourceProfileD
+	if profile {
+		script += sourceProfile
+	}
+	script += execCommand
+	return &exec.Cmd{
+		Path: bash,
+		Args: []string{"bash", "-c", script, "launcher", appDir, command},
+		Env:  Env(appDir, env),
+	}, nil
+}
+
+// Env returns env with HOME set to appDir, VCAP_APPLICATION updated with the instance env vars, and DATABASE_URL set
+// from the first bound service with a database URI.
+func Env(appDir string, env []string) []string {
+	vars := map[string]string{}
+	var keys []string
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if _, ok := vars[parts[0]]; !ok {
+			keys = append(keys, parts[0])
+		}
+		vars[parts[0]] = parts[1]
+	}
+	set := func(k, v string) {
+		if _, ok := vars[k]; !ok {
+			keys = append(keys, k)
+		}
+		vars[k] = v
+	}
+
+	set("HOME", appDir)
+
+	if vcapApp, ok := vcapApplication(vars); ok {
+		set("VCAP_APPLICATION", vcapApp)
+	}
+	if dbURL := databaseURL(vars["VCAP_SERVICES"]); dbURL != "" {
+		set("DATABASE_URL", dbURL)
+	}
+	delete(vars, "VCAP_PLATFORM_OPTIONS")
+
+	var out []string
+	for _, k := range keys {
+		if v, ok := vars[k]; ok {
+			out = append(out, k+"="+v)
+		}
+	}
+	return out
+}
+
+func vcapApplication(vars map[string]string) (string, bool) {
+	vcapApp := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(vars["VCAP_APPLICATION"]), &vcapApp); err != nil {
+		return "", false
+	}
+	vcapApp["host"] = "0.0.0.0"
+	vcapApp["instance_id"] = vars["INSTANCE_GUID"]
+	if port, err := strconv.Atoi(vars["PORT"]); err == nil {
+		vcapApp["port"] = port
+	}
+	if index, err := strconv.Atoi(vars["INSTANCE_INDEX"]); err == nil {
+		vcapApp["instance_index"] = index
+	}
+	out, err := json.Marshal(vcapApp)
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+func databaseURL(vcapServices string) string {
+	var services map[string][]struct {
+		Credentials struct {
+			URI string `json:"uri"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal([]byte(vcapServices), &services); err != nil {
+		return ""
+	}
+	var labels []string
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		for _, s := range services[label] {
+			uri, err := url.Parse(s.Credentials.URI)
+			if err != nil || s.Credentials.URI == "" {
+				continue
+			}
+			if scheme, ok := databaseSchemes[uri.Scheme]; ok {
+				uri.Scheme = scheme
+				return uri.String()
+			}
+		}
+	}
+	return ""
+}
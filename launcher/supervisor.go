@@ -0,0 +1,252 @@
+package launcher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/buildpack/packs/procfile"
+)
+
+const (
+	defaultGracePeriod = 10 * time.Second
+	portsPerType       = 100
+	colorReset         = "\x1b[0m"
+)
+
+var colors = []string{"\x1b[36m", "\x1b[33m", "\x1b[32m", "\x1b[35m", "\x1b[34m", "\x1b[31m"}
+
+// Process is a single instance of a process type.
+type Process struct {
+	Type    string
+	Index   int
+	Port    int
+	Command string
+	Env     []string
+}
+
+// Name returns the type and the one-based index of the instance, such as
+// web.1.
+func (p Process) Name() string {
+	return p.Type + "." + strconv.Itoa(p.Index+1)
+}
+
+// Processes returns the instances described by formation, a comma-separated
+// list of process types with optional counts, such as web,worker=2. Each
+// type is assigned a block of ports above basePort, and each instance gets
+// the next port in that block.
+func Processes(formation string, commands map[string]string, basePort int) ([]Process, error) {
+	var procs []Process
+	for i, entry := range strings.Split(formation, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		processType, count := kv[0], 1
+		if len(kv) == 2 {
+			var err error
+			if count, err = strconv.Atoi(kv[1]); err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid count for process type %s: %s", processType, kv[1])
+			}
+		}
+		command, err := procfile.Lookup(commands, processType)
+		if err != nil {
+			return nil, err
+		}
+		for index := 0; index < count; index++ {
+			port := basePort + i*portsPerType + index
+			procs = append(procs, Process{
+				Type:    processType,
+				Index:   index,
+				Port:    port,
+				Command: command,
+				Env:     []string{"PORT=" + strconv.Itoa(port)},
+			})
+		}
+	}
+	return procs, nil
+}
+
+// ExitError reports the process that caused the supervisor to stop.
+type ExitError struct {
+	Name string
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s exited with code %d", e.Name, e.Code)
+}
+
+// Supervisor runs several processes from the same app, like foreman.
+type Supervisor struct {
+	AppDir      string
+	Env         []string
+	Out         io.Writer
+	Color       bool
+	GracePeriod time.Duration
+}
+
+// Run starts every process and waits for all of them to exit. SIGTERM and
+// SIGINT are forwarded to each process. If a process fails, the others are
+// terminated and an *ExitError is returned for the failed process.
+func (s *Supervisor) Run(procs []Process) error {
+	out := &syncWriter{w: s.Out}
+	if out.w == nil {
+		out.w = os.Stdout
+	}
+	grace := s.GracePeriod
+	if grace == 0 {
+		grace = defaultGracePeriod
+	}
+	width := len("system")
+	for _, p := range procs {
+		if len(p.Name()) > width {
+			width = len(p.Name())
+		}
+	}
+	system := s.prefixWriter(out, "system", width, "")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	type result struct {
+		proc Process
+		err  error
+	}
+	done := make(chan result, len(procs))
+	var cmds []*exec.Cmd
+	var startErr error
+	for i, p := range procs {
+		cmd, err := Cmd(s.AppDir, p.Command, append(append([]string{}, s.Env...), p.Env...))
+		if err != nil {
+			startErr = fmt.Errorf("failed to start %s: %s", p.Name(), err)
+			break
+		}
+		w := s.prefixWriter(out, p.Name(), width, colors[i%len(colors)])
+		cmd.Stdout, cmd.Stderr = w, w
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := cmd.Start(); err != nil {
+			startErr = fmt.Errorf("failed to start %s: %s", p.Name(), err)
+			break
+		}
+		fmt.Fprintf(system, "started %s with pid %d\n", p.Name(), cmd.Process.Pid)
+		cmds = append(cmds, cmd)
+		go func(p Process, cmd *exec.Cmd, w *prefixWriter) {
+			err := cmd.Wait()
+			w.Flush()
+			done <- result{p, err}
+		}(p, cmd, w)
+	}
+
+	var (
+		exitErr  error
+		stopping bool
+		kill     <-chan time.Time
+	)
+	stop := func(sig os.Signal) {
+		stopping = true
+		signalAll(cmds, sig)
+		kill = time.After(grace)
+	}
+	if startErr != nil {
+		fmt.Fprintf(system, "%s\n", startErr)
+		exitErr = startErr
+		stop(syscall.SIGTERM)
+	}
+	for running := len(cmds); running > 0; {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(system, "received %s, stopping all processes\n", sig)
+			stop(sig)
+		case r := <-done:
+			running--
+			code := exitCode(r.err)
+			fmt.Fprintf(system, "%s exited with code %d\n", r.proc.Name(), code)
+			if code != 0 && !stopping {
+				exitErr = &ExitError{Name: r.proc.Name(), Code: code}
+				stop(syscall.SIGTERM)
+			}
+		case <-kill:
+			fmt.Fprintf(system, "killing processes after %s\n", grace)
+			signalAll(cmds, syscall.SIGKILL)
+			kill = nil
+		}
+	}
+	system.Flush()
+	return exitErr
+}
+
+func (s *Supervisor) prefixWriter(out io.Writer, name string, width int, color string) *prefixWriter {
+	prefix := fmt.Sprintf("%-*s | ", width, name)
+	if s.Color && color != "" {
+		prefix = color + prefix + colorReset
+	}
+	return &prefixWriter{w: out, prefix: prefix}
+}
+
+func signalAll(cmds []*exec.Cmd, sig os.Signal) {
+	for _, cmd := range cmds {
+		syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+	}
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// prefixWriter writes each complete line with a prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := p.w.Write(append([]byte(p.prefix), p.buf[:i+1]...)); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes any incomplete line that remains.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
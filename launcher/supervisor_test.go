@@ -0,0 +1,114 @@
+package launcher_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/launcher"
+)
+
+func testProcesses(t *testing.T, when spec.G, it spec.S) {
+	commands := map[string]string{"web": "some-web-command", "worker": "some-worker-command"}
+
+	it("should assign each instance a port and index", func() {
+		procs, err := launcher.Processes("web, worker=2", commands, 5000)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		expected := []launcher.Process{
+			{Type: "web", Index: 0, Port: 5000, Command: "some-web-command", Env: []string{"PORT=5000"}},
+			{Type: "worker", Index: 0, Port: 5100, Command: "some-worker-command", Env: []string{"PORT=5100"}},
+			{Type: "worker", Index: 1, Port: 5101, Command: "some-worker-command", Env: []string{"PORT=5101"}},
+		}
+		if !reflect.DeepEqual(procs, expected) {
+			t.Fatalf("Mismatched processes:\n%#v\n!=\n%#v\n", procs, expected)
+		}
+		if name := procs[2].Name(); name != "worker.2" {
+			t.Fatalf("Incorrect name: %s\n", name)
+		}
+	})
+
+	it("should return an error listing the available types", func() {
+		_, err := launcher.Processes("web,clock", commands, 5000)
+		if err == nil || !strings.Contains(err.Error(), "web, worker") {
+			t.Fatalf("Expected error listing types, got: %v\n", err)
+		}
+	})
+
+	it("should return an error for an invalid count", func() {
+		if _, err := launcher.Processes("web=some-count", commands, 5000); err == nil {
+			t.Fatal("Expected error")
+		}
+	})
+}
+
+func testSupervisor(t *testing.T, when spec.G, it spec.S) {
+	var (
+		appDir string
+		out    *bytes.Buffer
+		s      *launcher.Supervisor
+	)
+
+	it.Before(func() {
+		var err error
+		if appDir, err = ioutil.TempDir("", "pack.supervisor.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		out = &bytes.Buffer{}
+		s = &launcher.Supervisor{
+			AppDir:      appDir,
+			Env:         []string{"SOME_VAR=some-value"},
+			Out:         out,
+			GracePeriod: time.Second,
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(appDir)
+	})
+
+	it("should run every process with prefixed output", func() {
+		procs, err := launcher.Processes("web,worker=2", map[string]string{
+			"web":    `echo "web $PORT $SOME_VAR"`,
+			"worker": `printf "worker $PORT"`,
+		}, 5000)
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if err := s.Run(procs); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		for _, line := range []string{
+			"web.1    | web 5000 some-value\n",
+			"worker.1 | worker 5100\n",
+			"worker.2 | worker 5101\n",
+			"system   | worker.2 exited with code 0\n",
+		} {
+			if !strings.Contains(out.String(), line) {
+				t.Fatalf("Missing output %q in:\n%s\n", line, out)
+			}
+		}
+	})
+
+	it("should stop the other processes when one fails", func() {
+		start := time.Now()
+		err := s.Run([]launcher.Process{
+			{Type: "web", Command: "sleep 10"},
+			{Type: "worker", Command: "exit 3"},
+		})
+		exitErr, ok := err.(*launcher.ExitError)
+		if !ok || exitErr.Name != "worker.1" || exitErr.Code != 3 {
+			t.Fatalf("Unexpected error: %#v\n", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Processes were not stopped:\n%s\n", out)
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package packs
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	killGracePeriod = 10 * time.Second
+
+	// not exported by syscall on linux
+	rlimitNproc = 6
+
+	shellPath = "/bin/bash"
+)
+
+var startTime = time.Now()
+
+type BuildLimits struct {
+	Timeout       time.Duration
+	PhaseTimeouts PhaseTimeouts
+
+	CPUSeconds  uint64
+	MaxProcs    uint64
+	MaxFileSize uint64
+}
+
+type PhaseTimeouts map[string]time.Duration
+
+func (p PhaseTimeouts) String() string {
+	var out []string
+	for phase, timeout := range p {
+		out = append(out, phase+"="+timeout.String())
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func (p PhaseTimeouts) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid phase timeout: %s", pair)
+		}
+		timeout, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return err
+		}
+		p[strings.TrimSpace(kv[0])] = timeout
+	}
+	return nil
+}
+
+// phaseTimeoutsValue is a flag.Value for PhaseTimeouts that only accepts
+// the phases run by a builder.
+type phaseTimeoutsValue struct {
+	timeouts PhaseTimeouts
+	phases   []string
+}
+
+func (p *phaseTimeoutsValue) String() string {
+	return p.timeouts.String()
+}
+
+func (p *phaseTimeoutsValue) Set(value string) error {
+	timeouts := PhaseTimeouts{}
+	if err := timeouts.Set(value); err != nil {
+		return err
+	}
+	for phase, timeout := range timeouts {
+		if !contains(p.phases, phase) {
+			return fmt.Errorf("unknown phase %s: must be one of %s", phase, strings.Join(p.phases, ", "))
+		}
+		p.timeouts[phase] = timeout
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs cmd as the named build phase. When a timeout applies, cmd is
+// started in its own process group so that the whole group can be killed.
+// If the stdin of cmd is a terminal, cmd stays in the foreground process
+// group of the terminal so that it can read from it, and only cmd is
+// killed.
+func (l *BuildLimits) Run(cmd *exec.Cmd, phase string) error {
+	timeout, total := l.timeout(phase)
+	if timeout < 0 {
+		return l.timeoutErr(phase, total)
+	}
+	stdin, ok := cmd.Stdin.(*os.File)
+	interactive := ok && IsTerminal(stdin)
+	if timeout > 0 && !interactive {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
+	}
+	if err := l.start(cmd); err != nil {
+		return err
+	}
+	if timeout == 0 {
+		return cmd.Wait()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+	}
+	target := -cmd.Process.Pid
+	if interactive {
+		target = cmd.Process.Pid
+	}
+	syscall.Kill(target, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(killGracePeriod):
+		syscall.Kill(target, syscall.SIGKILL)
+		<-done
+	}
+	return l.timeoutErr(phase, total)
+}
+
+// timeout returns the time remaining for phase, which is negative if the
+// overall deadline has passed and zero if there is no limit.
+func (l *BuildLimits) timeout(phase string) (timeout time.Duration, total bool) {
+	timeout = l.PhaseTimeouts[phase]
+	if l.Timeout > 0 {
+		remaining := l.Timeout - time.Since(startTime)
+		if remaining <= 0 {
+			return -1, true
+		}
+		if timeout == 0 || remaining < timeout {
+			return remaining, true
+		}
+	}
+	return timeout, false
+}
+
+func (l *BuildLimits) timeoutErr(phase string, total bool) error {
+	if total {
+		return FailCode(CodeTimeout, "build within", l.Timeout.String(), "during", phase, "phase")
+	}
+	return FailCode(CodeTimeout, "complete", phase, "phase within", l.PhaseTimeouts[phase].String())
+}
+
+// start starts cmd with the configured soft rlimits. Since SysProcAttr has
+// no rlimit support, cmd is wrapped in a shell that sets the rlimits on
+// itself before it execs cmd, so that only cmd and its children are
+// limited. Limits above the current hard limit cannot be set, so they
+// fail with CodeInvalidArgs.
+func (l *BuildLimits) start(cmd *exec.Cmd) error {
+	var ulimits []string
+	for _, limit := range []struct {
+		name     string
+		resource int
+		option   string
+		value    uint64
+		unit     uint64
+	}{
+		{"CPU time", syscall.RLIMIT_CPU, "t", l.CPUSeconds, 1},
+		{"process", rlimitNproc, "u", l.MaxProcs, 1},
+		{"file size", syscall.RLIMIT_FSIZE, "f", l.MaxFileSize, 1024},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		var current syscall.Rlimit
+		if err := syscall.Getrlimit(limit.resource, &current); err != nil {
+			return FailErr(err, "get rlimit")
+		}
+		if limit.value > current.Max {
+			return FailCode(CodeInvalidArgs, "set", limit.name, "rlimit", strconv.FormatUint(limit.value, 10),
+				"above hard limit", strconv.FormatUint(current.Max, 10))
+		}
+		// bash sets file sizes in 1024-byte blocks, so round up
+		value := (limit.value + limit.unit - 1) / limit.unit
+		ulimits = append(ulimits, fmt.Sprintf("ulimit -S -%s %d", limit.option, value))
+	}
+	if len(ulimits) > 0 {
+		script := strings.Join(append(ulimits, `exec "$0" "$@"`), " && ")
+		cmd.Args = append([]string{"bash", "-c", script, cmd.Path}, cmd.Args[1:]...)
+		cmd.Path = shellPath
+	}
+	return cmd.Start()
+}
@@ -0,0 +1,188 @@
+package packs_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+)
+
+func TestLimits(t *testing.T) {
+	spec.Run(t, "#BuildLimits", testBuildLimits)
+	spec.Run(t, "#InputBuildLimits", testInputBuildLimits)
+}
+
+func testBuildLimits(t *testing.T, when spec.G, it spec.S) {
+	var limits *packs.BuildLimits
+
+	it.Before(func() {
+		limits = &packs.BuildLimits{PhaseTimeouts: packs.PhaseTimeouts{}}
+	})
+
+	it("should run the command to completion", func() {
+		out := &bytes.Buffer{}
+		cmd := exec.Command("echo", "some-output")
+		cmd.Stdout = out
+		if err := limits.Run(cmd, "build"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if out.String() != "some-output\n" {
+			t.Fatalf("Incorrect output: %s\n", out)
+		}
+	})
+
+	when("the phase timeout expires", func() {
+		it("should kill the command and fail with CodeTimeout", func() {
+			limits.Timeout = time.Hour
+			limits.PhaseTimeouts["detect"] = 100 * time.Millisecond
+			start := time.Now()
+			err := limits.Run(exec.Command("sleep", "10"), "detect")
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("Command not killed after %s\n", elapsed)
+			}
+			checkTimeout(t, err, "complete detect phase within 100ms")
+		})
+	})
+
+	when("the total timeout has expired", func() {
+		it("should fail with CodeTimeout without running the command", func() {
+			limits.Timeout = time.Nanosecond
+			limits.PhaseTimeouts["build"] = time.Hour
+			cmd := exec.Command("true")
+			checkTimeout(t, limits.Run(cmd, "build"), "build within 1ns during build phase")
+			if cmd.Process != nil {
+				t.Fatal("Expected command not to run")
+			}
+		})
+	})
+
+	when("an rlimit is above the hard limit", func() {
+		it("should fail with CodeInvalidArgs without running the command", func() {
+			var current syscall.Rlimit
+			if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &current); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if current.Max == ^uint64(0) {
+				if os.Geteuid() != 0 {
+					t.Skip("CPU rlimit has no hard limit")
+				}
+				// only root can restore the hard limit afterwards
+				lowered := syscall.Rlimit{Cur: 1000, Max: 1000}
+				if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &lowered); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				defer syscall.Setrlimit(syscall.RLIMIT_CPU, &current)
+				current = lowered
+			}
+			limits.CPUSeconds = current.Max + 1
+			cmd := exec.Command("true")
+			err := limits.Run(cmd, "build")
+			if err, ok := err.(*packs.ErrorFail); !ok || err.Code != packs.CodeInvalidArgs {
+				t.Fatalf("Incorrect error: %#v\n", err)
+			}
+			if cmd.Process != nil {
+				t.Fatal("Expected command not to run")
+			}
+		})
+	})
+
+	when("rlimits are set", func() {
+		it("should apply them to the command but not the caller", func() {
+			var before syscall.Rlimit
+			if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &before); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if before.Max <= 100 {
+				t.Skip("CPU rlimit is below the test value")
+			}
+			limits.CPUSeconds = 100
+			limits.MaxFileSize = 2000
+			out := &bytes.Buffer{}
+			cmd := exec.Command("bash", "-c", "ulimit -S -t; ulimit -S -f")
+			cmd.Stdout = out
+			if err := limits.Run(cmd, "build"); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if out.String() != "100\n2\n" {
+				t.Fatalf("Incorrect rlimits: %q\n", out)
+			}
+			var after syscall.Rlimit
+			if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &after); err != nil {
+				t.Fatalf("Error: %s\n", err)
+			}
+			if after != before {
+				t.Fatalf("Caller rlimit changed: %+v != %+v\n", after, before)
+			}
+		})
+	})
+}
+
+func testInputBuildLimits(t *testing.T, when spec.G, it spec.S) {
+	var (
+		flags  *flag.FlagSet
+		limits packs.BuildLimits
+	)
+
+	it.Before(func() {
+		flags = flag.NewFlagSet("test", flag.ContinueOnError)
+		limits = packs.BuildLimits{}
+	})
+
+	it.After(func() {
+		os.Unsetenv(packs.EnvBuildTimeout)
+		os.Unsetenv(packs.EnvPhaseTimeouts)
+	})
+
+	it("should read limits from the env", func() {
+		os.Setenv(packs.EnvBuildTimeout, "10m")
+		os.Setenv(packs.EnvPhaseTimeouts, "detect=1m,build=5m")
+		if err := packs.InputBuildLimits(flags, &limits, "detect", "build"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if limits.Timeout != 10*time.Minute {
+			t.Fatalf("Incorrect timeout: %s\n", limits.Timeout)
+		}
+		if s := limits.PhaseTimeouts.String(); s != "build=5m0s,detect=1m0s" {
+			t.Fatalf("Incorrect phase timeouts: %s\n", s)
+		}
+	})
+
+	for _, tt := range []struct{ k, v string }{
+		{packs.EnvBuildTimeout, "10"},
+		{packs.EnvPhaseTimeouts, "detect"},
+		{packs.EnvPhaseTimeouts, "compile=1m"},
+	} {
+		tt := tt
+		when(tt.k+" is invalid: "+tt.v, func() {
+			it("should fail with CodeInvalidArgs", func() {
+				os.Setenv(tt.k, tt.v)
+				err := packs.InputBuildLimits(flags, &limits, "detect", "build")
+				if err, ok := err.(*packs.ErrorFail); !ok || err.Code != packs.CodeInvalidArgs {
+					t.Fatalf("Incorrect error: %#v\n", err)
+				}
+				if !strings.Contains(err.Error(), tt.k) {
+					t.Fatalf("Missing env var in error: %s\n", err)
+				}
+			})
+		})
+	}
+}
+
+func checkTimeout(t *testing.T, err error, message string) {
+	t.Helper()
+	fail, ok := err.(*packs.ErrorFail)
+	if !ok || fail.Code != packs.CodeTimeout {
+		t.Fatalf("Incorrect error: %#v\n", err)
+	}
+	if !strings.Contains(err.Error(), message) {
+		t.Fatalf("Incorrect error: %s\n", err)
+	}
+}
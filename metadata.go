@@ -3,12 +3,14 @@ package packs
 const (
 	BuildLabel     = "sh.packs.build"
 	BuildpackLabel = "sh.packs.buildpacks"
+	StackLabel     = "sh.packs.stack"
 )
 
 type BuildMetadata struct {
 	App        AppMetadata         `json:"app"`
 	Buildpacks []BuildpackMetadata `json:"buildpacks"`
 	RunImage   RunImageMetadata    `json:"runimage"`
+	Stack      string              `json:"stack,omitempty"`
 }
 
 type BuildpackMetadata struct {
@@ -0,0 +1,99 @@
+package packs
+
+import (
+	"strconv"
+	"strings"
+)
+
+type Ports []uint
+
+func ParsePorts(value string) (Ports, error) {
+	var ports Ports
+	if err := ports.Set(value); err != nil {
+		return nil, err
+	}
+	return ports, nil
+}
+
+func (p *Ports) String() string {
+	var out []string
+	for _, port := range *p {
+		out = append(out, strconv.FormatUint(uint64(port), 10))
+	}
+	return strings.Join(out, ",")
+}
+
+func (p *Ports) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		port, err := ParsePort(s)
+		if err != nil {
+			return err
+		}
+		*p = append(*p, port)
+	}
+	return nil
+}
+
+// ParsePort parses a port between 1 and 65535.
+func ParsePort(s string) (uint, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, FailCode(CodeInvalidArgs, "parse port", s)
+	}
+	return uint(port), nil
+}
+
+// portValue is a flag.Value for a port between 1 and 65535.
+type portValue uint
+
+func (p *portValue) String() string {
+	return strconv.FormatUint(uint64(*p), 10)
+}
+
+func (p *portValue) Set(value string) error {
+	port, err := ParsePort(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	*p = portValue(port)
+	return nil
+}
+
+// portsValue is a flag.Value for Ports that replaces the default ports the
+// first time it is set, and adds to them after that.
+type portsValue struct {
+	ports *Ports
+	set   bool
+}
+
+func (p *portsValue) String() string {
+	if p.ports == nil {
+		return ""
+	}
+	return p.ports.String()
+}
+
+func (p *portsValue) Set(value string) error {
+	if !p.set {
+		*p.ports, p.set = nil, true
+	}
+	return p.ports.Set(value)
+}
+
+// With returns port followed by the other ports, without duplicates.
+func (p Ports) With(port uint) Ports {
+	out := Ports{port}
+	for _, other := range p {
+		dup := false
+		for _, seen := range out {
+			dup = dup || seen == other
+		}
+		if !dup {
+			out = append(out, other)
+		}
+	}
+	return out
+}
@@ -0,0 +1,43 @@
+package packs_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs"
+)
+
+func TestPorts(t *testing.T) {
+	spec.Run(t, "#Ports", testPorts)
+}
+
+func testPorts(t *testing.T, when spec.G, it spec.S) {
+	it("should parse a comma-separated list of ports", func() {
+		ports, err := packs.ParsePorts("8080, 9090,,1")
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if !reflect.DeepEqual(ports, packs.Ports{8080, 9090, 1}) {
+			t.Fatalf("Incorrect ports: %v\n", ports)
+		}
+	})
+
+	for _, value := range []string{"0", "65536", "some-port", "8080,-1"} {
+		value := value
+		it("should fail with CodeInvalidArgs for "+value, func() {
+			_, err := packs.ParsePorts(value)
+			if err, ok := err.(*packs.ErrorFail); !ok || err.Code != packs.CodeInvalidArgs {
+				t.Fatalf("Incorrect error: %#v\n", err)
+			}
+		})
+	}
+
+	it("should put the port first without duplicates", func() {
+		ports := packs.Ports{9090, 8080}.With(8080)
+		if !reflect.DeepEqual(ports, packs.Ports{8080, 9090}) {
+			t.Fatalf("Incorrect ports: %v\n", ports)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+// Package procfile parses Procfiles, which declare the command that runs
+// each process type of an app.
+package procfile
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrMissingColon = errors.New("expected <process type>: <command>")
+	ErrInvalidType  = errors.New("process type may only contain letters, digits, dashes and underscores")
+	ErrEmptyCommand = errors.New("command is empty")
+)
+
+var validType = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var bom = []byte("\xef\xbb\xbf")
+
+// ParseError reports an invalid line of a Procfile. Err is one of
+// ErrMissingColon, ErrInvalidType or ErrEmptyCommand.
+type ParseError struct {
+	Path string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "Procfile"
+	}
+	return fmt.Sprintf("%s:%d: %s: %q", path, e.Line, e.Err, e.Text)
+}
+
+// UnknownTypeError reports a process type that is not declared.
+type UnknownTypeError struct {
+	Type      string
+	Available []string
+}
+
+func (e *UnknownTypeError) Error() string {
+	if len(e.Available) == 0 {
+		return fmt.Sprintf("unknown process type %s, no process types are declared", e.Type)
+	}
+	return fmt.Sprintf("unknown process type %s, available types: %s", e.Type, strings.Join(e.Available, ", "))
+}
+
+// Parse returns the commands declared in r by process type, or a
+// *ParseError for the first invalid line. Blank lines and lines starting
+// with # are ignored, and the last command declared for a type is used.
+func Parse(r io.Reader) (map[string]string, error) {
+	processTypes := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if line == 1 {
+			text = bytes.TrimPrefix(text, bom)
+		}
+		processType, command, err := parseLine(strings.TrimSpace(string(text)))
+		if err != nil {
+			return nil, &ParseError{Line: line, Text: scanner.Text(), Err: err}
+		}
+		if processType != "" {
+			processTypes[processType] = command
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return processTypes, nil
+}
+
+func parseLine(line string) (processType, command string, err error) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", nil
+	}
+	kv := strings.SplitN(line, ":", 2)
+	if len(kv) != 2 {
+		return "", "", ErrMissingColon
+	}
+	processType, command = strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+	if !validType.MatchString(processType) {
+		return "", "", ErrInvalidType
+	}
+	if command == "" {
+		return "", "", ErrEmptyCommand
+	}
+	return processType, command, nil
+}
+
+// Read parses the Procfile at path. It returns nil without an error if the
+// file does not exist.
+func Read(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	processTypes, err := Parse(f)
+	if err, ok := err.(*ParseError); ok {
+		err.Path = path
+	}
+	return processTypes, err
+}
+
+// Lookup returns the command for processType, or an *UnknownTypeError if
+// it is not one of processTypes.
+func Lookup(processTypes map[string]string, processType string) (string, error) {
+	if command, ok := processTypes[processType]; ok {
+		return command, nil
+	}
+	return "", &UnknownTypeError{Type: processType, Available: Types(processTypes)}
+}
+
+// Types returns the process types in processTypes in sorted order.
+func Types(processTypes map[string]string) []string {
+	var types []string
+	for t := range processTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
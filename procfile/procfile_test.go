@@ -0,0 +1,136 @@
+package procfile_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/procfile"
+)
+
+func TestProcfile(t *testing.T) {
+	spec.Run(t, "#Parse", testParse)
+	spec.Run(t, "#Read", testRead)
+	spec.Run(t, "#Lookup", testLookup)
+}
+
+func testParse(t *testing.T, when spec.G, it spec.S) {
+	for _, tt := range []struct {
+		name, procfile string
+		expected       map[string]string
+	}{
+		{"a single process type", "web: some-command\n", map[string]string{"web": "some-command"}},
+		{"no trailing newline", "web: some-command", map[string]string{"web": "some-command"}},
+		{"an empty file", "", map[string]string{}},
+		{"CRLF line endings", "web: some-command\r\nworker: other-command\r\n", map[string]string{"web": "some-command", "worker": "other-command"}},
+		{"whitespace around the process type", "  web  :some-command  \n", map[string]string{"web": "some-command"}},
+		{"comments and blank lines", "# some comment\n\n  # indented comment\nweb: some-command\n\t\n", map[string]string{"web": "some-command"}},
+		{"colons in the command", "web: some-command --bind 0.0.0.0:$PORT", map[string]string{"web": "some-command --bind 0.0.0.0:$PORT"}},
+		{"a # in the command", "web: some-command # not a comment", map[string]string{"web": "some-command # not a comment"}},
+		{"dashes and underscores in process types", "some-type_2: some-command", map[string]string{"some-type_2": "some-command"}},
+		{"a byte order mark", "\xef\xbb\xbfweb: some-command", map[string]string{"web": "some-command"}},
+		{"a duplicate process type", "web: some-command\nweb: other-command", map[string]string{"web": "other-command"}},
+	} {
+		tt := tt
+		when("the Procfile has "+tt.name, func() {
+			it("should return the process types", func() {
+				processTypes, err := procfile.Parse(strings.NewReader(tt.procfile))
+				if err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				if !reflect.DeepEqual(processTypes, tt.expected) {
+					t.Fatalf("Mismatched process types:\n%#v\n!=\n%#v\n", processTypes, tt.expected)
+				}
+			})
+		})
+	}
+
+	for _, tt := range []struct {
+		name, procfile string
+		line           int
+		err            error
+	}{
+		{"a line without a colon", "web: some-command\nsome-command\n", 2, procfile.ErrMissingColon},
+		{"a space in the process type", "some web: some-command", 1, procfile.ErrInvalidType},
+		{"an empty process type", ": some-command", 1, procfile.ErrInvalidType},
+		{"a dot in the process type", "web.1: some-command", 1, procfile.ErrInvalidType},
+		{"an empty command", "# comment\r\n\r\nweb:   \r\n", 3, procfile.ErrEmptyCommand},
+	} {
+		tt := tt
+		when("the Procfile has "+tt.name, func() {
+			it("should return a *ParseError for the line", func() {
+				_, err := procfile.Parse(strings.NewReader(tt.procfile))
+				parseErr, ok := err.(*procfile.ParseError)
+				if !ok {
+					t.Fatalf("Expected *ParseError, got: %#v\n", err)
+				}
+				if parseErr.Line != tt.line || parseErr.Err != tt.err {
+					t.Fatalf("Incorrect error: %s\n", parseErr)
+				}
+			})
+		})
+	}
+}
+
+func testRead(t *testing.T, when spec.G, it spec.S) {
+	var tmpDir string
+
+	it.Before(func() {
+		var err error
+		if tmpDir, err = ioutil.TempDir("", "pack.procfile.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	it("should return nil if there is no Procfile", func() {
+		if processTypes, err := procfile.Read(filepath.Join(tmpDir, "Procfile")); err != nil || processTypes != nil {
+			t.Fatalf("Unexpected result: %#v, %v\n", processTypes, err)
+		}
+	})
+
+	it("should include the path in parse errors", func() {
+		path := filepath.Join(tmpDir, "Procfile")
+		if err := ioutil.WriteFile(path, []byte("web\n"), 0666); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		_, err := procfile.Read(path)
+		if err == nil || !strings.HasPrefix(err.Error(), path+":1: ") {
+			t.Fatalf("Incorrect error: %v\n", err)
+		}
+	})
+}
+
+func testLookup(t *testing.T, when spec.G, it spec.S) {
+	processTypes := map[string]string{"web": "some-command", "worker": "other-command"}
+
+	it("should return the command for the process type", func() {
+		if command, err := procfile.Lookup(processTypes, "worker"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		} else if command != "other-command" {
+			t.Fatalf("Incorrect command: %s\n", command)
+		}
+	})
+
+	it("should return an *UnknownTypeError listing the available types", func() {
+		_, err := procfile.Lookup(processTypes, "clock")
+		unknownErr, ok := err.(*procfile.UnknownTypeError)
+		if !ok {
+			t.Fatalf("Expected *UnknownTypeError, got: %#v\n", err)
+		}
+		if !reflect.DeepEqual(unknownErr.Available, []string{"web", "worker"}) {
+			t.Fatalf("Incorrect available types: %v\n", unknownErr.Available)
+		}
+		if msg := err.Error(); msg != "unknown process type clock, available types: web, worker" {
+			t.Fatalf("Incorrect message: %s\n", msg)
+		}
+	})
+}
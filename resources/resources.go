@@ -0,0 +1,147 @@
+package resources
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	cgroupV2MemPath      = "sys/fs/cgroup/memory.max"
+	cgroupV1MemPath      = "sys/fs/cgroup/memory/memory.limit_in_bytes"
+	cgroupV1MemUnlimited = 9223372036854771712
+	memInfoPath          = "proc/meminfo"
+
+	cgroupV2CPUPath = "sys/fs/cgroup/cpu.max"
+	cgroupUnlimited = "max"
+)
+
+var cgroupV1CPUDirs = []string{"sys/fs/cgroup/cpu", "sys/fs/cgroup/cpu,cpuacct"}
+
+// Host reads resource limits from the filesystem rooted at Root.
+type Host struct {
+	Root string
+}
+
+// Memory returns the memory limit in bytes, read from cgroup v2, then
+// cgroup v1, and then /proc/meminfo. It returns false if the cgroup
+// imposes no limit.
+func (h Host) Memory() (bytes uint64, limited bool, err error) {
+	if v, err := h.read(cgroupV2MemPath); err == nil {
+		if v == cgroupUnlimited {
+			return 0, false, nil
+		}
+		bytes, err := strconv.ParseUint(v, 10, 64)
+		return bytes, err == nil, err
+	} else if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+
+	if v, err := h.read(cgroupV1MemPath); err == nil {
+		bytes, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, false, err
+		}
+		if bytes >= cgroupV1MemUnlimited {
+			return 0, false, nil
+		}
+		return bytes, true, nil
+	} else if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+
+	return h.memInfo()
+}
+
+func (h Host) memInfo() (uint64, bool, error) {
+	f, err := os.Open(filepath.Join(h.Root, memInfoPath))
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, false, err
+		}
+		return kb * 1024, true, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, false, err
+	}
+	return 0, false, fmt.Errorf("no MemTotal in %s", memInfoPath)
+}
+
+// CPU returns the CPU quota in thousandths of a CPU, read from cgroup v2
+// and then cgroup v1. It returns false if there is no quota.
+func (h Host) CPU() (millis uint64, limited bool, err error) {
+	if v, err := h.read(cgroupV2CPUPath); err == nil {
+		fields := strings.Fields(v)
+		if len(fields) != 2 {
+			return 0, false, fmt.Errorf("invalid %s: %s", cgroupV2CPUPath, v)
+		}
+		if fields[0] == cgroupUnlimited {
+			return 0, false, nil
+		}
+		return quota(fields[0], fields[1])
+	} else if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+
+	for _, dir := range cgroupV1CPUDirs {
+		q, err := h.read(filepath.Join(dir, "cpu.cfs_quota_us"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, false, err
+		}
+		if strings.HasPrefix(q, "-") {
+			return 0, false, nil
+		}
+		p, err := h.read(filepath.Join(dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return 0, false, err
+		}
+		return quota(q, p)
+	}
+	return 0, false, nil
+}
+
+func quota(quota, period string) (uint64, bool, error) {
+	q, err := strconv.ParseUint(quota, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	p, err := strconv.ParseUint(period, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if p == 0 {
+		return 0, false, fmt.Errorf("invalid CPU period: %d", p)
+	}
+	return q * 1000 / p, true, nil
+}
+
+// Disk returns the total size in bytes of the filesystem containing path.
+func (h Host) Disk(path string) (uint64, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(filepath.Join(h.Root, path), &fs); err != nil {
+		return 0, err
+	}
+	return fs.Blocks * uint64(fs.Bsize), nil
+}
+
+func (h Host) read(path string) (string, error) {
+	contents, err := ioutil.ReadFile(filepath.Join(h.Root, path))
+	return strings.TrimSpace(string(contents)), err
+}
@@ -0,0 +1,191 @@
+package resources_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/sclevine/spec"
+
+	"github.com/buildpack/packs/resources"
+)
+
+func TestHost(t *testing.T) {
+	spec.Run(t, "#Memory", testMemory)
+	spec.Run(t, "#CPU", testCPU)
+	spec.Run(t, "#Disk", testDisk)
+}
+
+func testMemory(t *testing.T, when spec.G, it spec.S) {
+	var host resources.Host
+
+	it.Before(func() {
+		var err error
+		if host.Root, err = ioutil.TempDir("", "pack.resources.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		writeFile(t, host.Root, "proc/meminfo", "MemTotal:        2048000 kB\nMemFree:         1024000 kB\n")
+	})
+
+	it.After(func() {
+		os.RemoveAll(host.Root)
+	})
+
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		bytes   uint64
+		limited bool
+	}{
+		{"cgroup v2 has a limit", map[string]string{
+			"sys/fs/cgroup/memory.max": "104857600\n",
+		}, 104857600, true},
+		{"cgroup v2 is unlimited", map[string]string{
+			"sys/fs/cgroup/memory.max": "max\n",
+		}, 0, false},
+		{"cgroup v1 has a limit", map[string]string{
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "104857600\n",
+		}, 104857600, true},
+		{"cgroup v1 is unlimited", map[string]string{
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		}, 0, false},
+		{"both cgroup versions are present", map[string]string{
+			"sys/fs/cgroup/memory.max":                   "52428800\n",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "104857600\n",
+		}, 52428800, true},
+		{"there are no cgroups", nil, 2048000 * 1024, true},
+	} {
+		tc := tc
+		when(tc.name, func() {
+			it("should return the correct limit", func() {
+				for path, contents := range tc.files {
+					writeFile(t, host.Root, path, contents)
+				}
+				bytes, limited, err := host.Memory()
+				if err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				if bytes != tc.bytes || limited != tc.limited {
+					t.Fatalf("Incorrect memory: %d, %t != %d, %t\n", bytes, limited, tc.bytes, tc.limited)
+				}
+			})
+		})
+	}
+
+	when("no source is readable", func() {
+		it("should return an error", func() {
+			os.Remove(filepath.Join(host.Root, "proc", "meminfo"))
+			if _, _, err := host.Memory(); err == nil {
+				t.Fatal("Expected error")
+			}
+		})
+	})
+}
+
+func testCPU(t *testing.T, when spec.G, it spec.S) {
+	var host resources.Host
+
+	it.Before(func() {
+		var err error
+		if host.Root, err = ioutil.TempDir("", "pack.resources.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(host.Root)
+	})
+
+	for _, tc := range []struct {
+		name    string
+		files   map[string]string
+		millis  uint64
+		limited bool
+	}{
+		{"cgroup v2 has a quota", map[string]string{
+			"sys/fs/cgroup/cpu.max": "150000 100000\n",
+		}, 1500, true},
+		{"cgroup v2 has no quota", map[string]string{
+			"sys/fs/cgroup/cpu.max": "max 100000\n",
+		}, 0, false},
+		{"cgroup v1 has a quota", map[string]string{
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":  "50000\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us": "100000\n",
+		}, 500, true},
+		{"cgroup v1 has a quota in cpu,cpuacct", map[string]string{
+			"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_quota_us":  "200000\n",
+			"sys/fs/cgroup/cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		}, 2000, true},
+		{"cgroup v1 has no quota", map[string]string{
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":  "-1\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us": "100000\n",
+		}, 0, false},
+		{"there are no cgroups", nil, 0, false},
+	} {
+		tc := tc
+		when(tc.name, func() {
+			it("should return the correct quota", func() {
+				for path, contents := range tc.files {
+					writeFile(t, host.Root, path, contents)
+				}
+				millis, limited, err := host.CPU()
+				if err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				if millis != tc.millis || limited != tc.limited {
+					t.Fatalf("Incorrect CPU: %d, %t != %d, %t\n", millis, limited, tc.millis, tc.limited)
+				}
+			})
+		})
+	}
+}
+
+func testDisk(t *testing.T, when spec.G, it spec.S) {
+	var host resources.Host
+
+	it.Before(func() {
+		var err error
+		if host.Root, err = ioutil.TempDir("", "pack.resources.test"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	})
+
+	it.After(func() {
+		os.RemoveAll(host.Root)
+	})
+
+	it("should return the size of the filesystem", func() {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(host.Root, &fs); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		bytes, err := host.Disk("/")
+		if err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+		if expected := fs.Blocks * uint64(fs.Bsize); bytes != expected || bytes == 0 {
+			t.Fatalf("Incorrect disk: %d != %d\n", bytes, expected)
+		}
+	})
+
+	when("the path does not exist", func() {
+		it("should return an error", func() {
+			if _, err := host.Disk("missing"); !os.IsNotExist(err) {
+				t.Fatalf("Expected not exist error, got: %v\n", err)
+			}
+		})
+	})
+}
+
+func writeFile(t *testing.T, root, path, contents string) {
+	t.Helper()
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+}
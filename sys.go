@@ -7,6 +7,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
+	"unsafe"
 )
 
 const (
@@ -18,6 +20,8 @@ const (
 	CodeFailedBuild
 	CodeFailedLaunch
 	CodeFailedUpdate
+	CodeTimeout
+	CodeFailedVerify
 )
 
 type ErrorFail struct {
@@ -61,6 +65,13 @@ func Exit(err error) {
 	os.Exit(CodeFailed)
 }
 
+// IsTerminal returns true if f is a terminal.
+func IsTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
 func Run(name string, arg ...string) (string, error) {
 	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
 	cmd := exec.Command(name, arg...)
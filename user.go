@@ -0,0 +1,37 @@
+package packs
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+)
+
+// LookupUser returns the UID and GID of the named user.
+func LookupUser(name string) (uid, gid uint32, err error) {
+	usr, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, FailErr(err, "find user", name)
+	}
+	uid64, err := strconv.ParseUint(usr.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, FailErr(err, "parse uid", usr.Uid)
+	}
+	gid64, err := strconv.ParseUint(usr.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, FailErr(err, "parse gid", usr.Gid)
+	}
+	return uint32(uid64), uint32(gid64), nil
+}
+
+// ChownStdFds gives the named user ownership of stdout and stderr, so that
+// processes run as that user can write to them.
+func ChownStdFds(name string) error {
+	cmd := exec.Command("chown", name, "/dev/stdout", "/dev/stderr")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return FailErr(err, "fix permissions of stdout and stderr")
+	}
+	return nil
+}